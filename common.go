@@ -24,13 +24,22 @@ package main
 
 import (
 	"archive/zip"
+	"bufio"
 	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
+	"io/ioutil"
+	"net/url"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
+
+	"github.com/ulikunitz/xz"
 )
 
 const databaseFormat = 3
@@ -80,13 +89,37 @@ type dbTerm struct {
 	DefinitionTags []string
 	Rules          []string
 	Score          int
-	Glossary       []string
+	Glossary       []interface{}
 	Sequence       int
 	TermTags       []string
 }
 
 type dbTermList []dbTerm
 
+type dbStructuredContent struct {
+	Type    string      `json:"type"`
+	Content interface{} `json:"content"`
+}
+
+type dbContentNode struct {
+	Tag     string      `json:"tag"`
+	Content interface{} `json:"content,omitempty"`
+	Href    string      `json:"href,omitempty"`
+	Path    string      `json:"path,omitempty"`
+}
+
+func makeStructuredContent(content interface{}) dbStructuredContent {
+	return dbStructuredContent{Type: "structured-content", Content: content}
+}
+
+func makeQueryLink(query string, content interface{}) dbContentNode {
+	return dbContentNode{
+		Tag:     "a",
+		Href:    "?query=" + url.QueryEscape(query) + "&wildcards=off",
+		Content: content,
+	}
+}
+
 func (term *dbTerm) addDefinitionTags(tags ...string) {
 	term.DefinitionTags = appendStringUnique(term.DefinitionTags, tags...)
 }
@@ -99,6 +132,98 @@ func (term *dbTerm) addRules(rules ...string) {
 	term.Rules = appendStringUnique(term.Rules, rules...)
 }
 
+// termFilter selects terms by their tags. Each expression is a comma separated
+// list of alternatives, and each alternative a plus separated list of tags that
+// must all be present, so "P,news+ichi" matches popular terms as well as terms
+// tagged with both news and ichi.
+type termFilter struct {
+	include [][]string
+	exclude [][]string
+	fields  [][]string
+}
+
+func parseTagExpression(expression string) [][]string {
+	var alternatives [][]string
+	for _, alternative := range strings.Split(expression, ",") {
+		var tags []string
+		for _, tag := range strings.Split(alternative, "+") {
+			if tag = strings.TrimSpace(tag); len(tag) > 0 {
+				tags = append(tags, tag)
+			}
+		}
+
+		if len(tags) > 0 {
+			alternatives = append(alternatives, tags)
+		}
+	}
+
+	return alternatives
+}
+
+func makeTermFilter(options exportOptions) termFilter {
+	return termFilter{
+		include: parseTagExpression(options.includeTags),
+		exclude: parseTagExpression(options.excludeTags),
+		fields:  parseTagExpression(options.fieldTags),
+	}
+}
+
+func matchTagExpression(alternatives [][]string, tagSets ...[]string) bool {
+	for _, tags := range alternatives {
+		matched := true
+		for _, tag := range tags {
+			found := false
+			for _, tagSet := range tagSets {
+				if hasString(tag, tagSet) {
+					found = true
+					break
+				}
+			}
+
+			if !found {
+				matched = false
+				break
+			}
+		}
+
+		if matched {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (filter termFilter) matches(term dbTerm) bool {
+	return filter.matchesFields(term, term.DefinitionTags)
+}
+
+// matchesFields checks the field expression against fields rather than all
+// definition tags, for formats that keep subject fields apart from parts of
+// speech and other notes.
+func (filter termFilter) matchesFields(term dbTerm, fields []string) bool {
+	if len(filter.include) > 0 && !matchTagExpression(filter.include, term.DefinitionTags, term.TermTags) {
+		return false
+	}
+
+	if len(filter.fields) > 0 && !matchTagExpression(filter.fields, fields) {
+		return false
+	}
+
+	return !matchTagExpression(filter.exclude, term.DefinitionTags, term.TermTags)
+}
+
+func (terms dbTermList) filter(filter termFilter) dbTermList {
+	var results dbTermList
+	for _, term := range terms {
+		if filter.matches(term) {
+			results = append(results, term)
+		}
+	}
+
+	return results
+}
+
 func (terms dbTermList) crush() dbRecordList {
 	var results dbRecordList
 	for _, t := range terms {
@@ -156,7 +281,7 @@ func (kanji dbKanjiList) crush() dbRecordList {
 	return results
 }
 
-func writeDb(outputPath, title, revision string, sequenced bool, recordData map[string]dbRecordList, stride int, pretty bool) error {
+func writeDb(outputPath, title, revision, description string, sequenced bool, recordData map[string]dbRecordList, media map[string][]byte, stride int, pretty bool) error {
 	var zbuff bytes.Buffer
 	zip := zip.NewWriter(&zbuff)
 
@@ -201,16 +326,18 @@ func writeDb(outputPath, title, revision string, sequenced bool, recordData map[
 
 	var err error
 	var db struct {
-		Title     string `json:"title"`
-		Format    int    `json:"format"`
-		Revision  string `json:"revision"`
-		Sequenced bool   `json:"sequenced"`
+		Title       string `json:"title"`
+		Format      int    `json:"format"`
+		Revision    string `json:"revision"`
+		Sequenced   bool   `json:"sequenced"`
+		Description string `json:"description,omitempty"`
 	}
 
 	db.Title = title
 	db.Format = databaseFormat
 	db.Revision = revision
 	db.Sequenced = sequenced
+	db.Description = description
 
 	for recordType, recordEntries := range recordData {
 		if _, err := writeDbRecords(recordType, recordEntries); err != nil {
@@ -218,6 +345,23 @@ func writeDb(outputPath, title, revision string, sequenced bool, recordData map[
 		}
 	}
 
+	var mediaPaths []string
+	for path := range media {
+		mediaPaths = append(mediaPaths, path)
+	}
+
+	sort.Strings(mediaPaths)
+	for _, path := range mediaPaths {
+		zw, err := zip.Create(path)
+		if err != nil {
+			return err
+		}
+
+		if _, err := zw.Write(media[path]); err != nil {
+			return err
+		}
+	}
+
 	bytes, err := marshalJSON(db, pretty)
 	if err != nil {
 		return err
@@ -246,6 +390,40 @@ func writeDb(outputPath, title, revision string, sequenced bool, recordData map[
 	return fp.Close()
 }
 
+// siblingPath names an additional archive written next to outputPath, so
+// that "names.zip" split by "place" becomes "names_place.zip".
+func siblingPath(outputPath, suffix string) string {
+	extension := filepath.Ext(outputPath)
+	return fmt.Sprintf("%s_%s%s", strings.TrimSuffix(outputPath, extension), suffix, extension)
+}
+
+// parseKinds parses a comma separated selection from a fixed set of kinds,
+// where "all" selects everything and an empty value or "none" nothing.
+func parseKinds(value, name string, kinds []string) (map[string]bool, error) {
+	selected := make(map[string]bool)
+
+	switch value {
+	case "all":
+		for _, kind := range kinds {
+			selected[kind] = true
+		}
+		return selected, nil
+	case "", "none":
+		return selected, nil
+	}
+
+	for _, kind := range strings.Split(value, ",") {
+		kind = strings.TrimSpace(kind)
+		if !hasString(kind, kinds) {
+			return nil, fmt.Errorf("unrecognized %s kind '%s' (expected %s)", name, kind, strings.Join(kinds, ", "))
+		}
+
+		selected[kind] = true
+	}
+
+	return selected, nil
+}
+
 func appendStringUnique(target []string, source ...string) []string {
 	for _, str := range source {
 		if !hasString(str, target) {
@@ -266,7 +444,114 @@ func hasString(needle string, haystack []string) bool {
 	return false
 }
 
+type inputReader struct {
+	io.Reader
+	closers []io.Closer
+}
+
+func (r *inputReader) Close() error {
+	var err error
+	for i := len(r.closers) - 1; i >= 0; i-- {
+		if errCurr := r.closers[i].Close(); errCurr != nil && err == nil {
+			err = errCurr
+		}
+	}
+
+	return err
+}
+
+// openInput opens a dictionary source for reading, using stdin when the path
+// is "-" and transparently decompressing gzip, bzip2, xz and zip data based on
+// its leading magic bytes. Zip archives must contain a single file.
+func openInput(path string) (io.ReadCloser, error) {
+	var (
+		file  *os.File
+		input inputReader
+	)
+
+	if path == "-" {
+		file = os.Stdin
+	} else {
+		var err error
+		if file, err = os.Open(path); err != nil {
+			return nil, err
+		}
+
+		input.closers = append(input.closers, file)
+	}
+
+	buffered := bufio.NewReader(file)
+	magic, err := buffered.Peek(6)
+	if err != nil && err != io.EOF {
+		input.Close()
+		return nil, err
+	}
+
+	switch {
+	case bytes.HasPrefix(magic, []byte{0x1f, 0x8b}):
+		gzipReader, err := gzip.NewReader(buffered)
+		if err != nil {
+			input.Close()
+			return nil, err
+		}
+
+		input.Reader = gzipReader
+		input.closers = append(input.closers, gzipReader)
+	case bytes.HasPrefix(magic, []byte("BZh")):
+		input.Reader = bzip2.NewReader(buffered)
+	case bytes.HasPrefix(magic, []byte{0xfd, '7', 'z', 'X', 'Z', 0x00}):
+		xzReader, err := xz.NewReader(buffered)
+		if err != nil {
+			input.Close()
+			return nil, err
+		}
+
+		input.Reader = xzReader
+	case bytes.HasPrefix(magic, []byte("PK\x03\x04")):
+		data, err := ioutil.ReadAll(buffered)
+		if err != nil {
+			input.Close()
+			return nil, err
+		}
+
+		archive, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+		if err != nil {
+			input.Close()
+			return nil, err
+		}
+
+		var files []*zip.File
+		for _, archiveFile := range archive.File {
+			if !archiveFile.FileInfo().IsDir() {
+				files = append(files, archiveFile)
+			}
+		}
+
+		if len(files) != 1 {
+			input.Close()
+			return nil, fmt.Errorf("zip archive must contain exactly one file, found %d", len(files))
+		}
+
+		fileReader, err := files[0].Open()
+		if err != nil {
+			input.Close()
+			return nil, err
+		}
+
+		input.Reader = fileReader
+		input.closers = append(input.closers, fileReader)
+	default:
+		input.Reader = buffered
+	}
+
+	return &input, nil
+}
+
 func detectFormat(path string) (string, error) {
+	if path == "-" {
+		return "", errors.New("dictionary format must be specified when reading from stdin")
+	}
+
 	switch filepath.Ext(path) {
 	case ".sqlite":
 		return "rikai", nil
@@ -276,13 +561,21 @@ func detectFormat(path string) (string, error) {
 		return "termfreq", nil
 	}
 
-	switch filepath.Base(path) {
+	name := filepath.Base(path)
+	switch filepath.Ext(name) {
+	case ".gz", ".bz2", ".xz", ".zip":
+		name = strings.TrimSuffix(name, filepath.Ext(name))
+	}
+
+	switch name {
 	case "JMdict", "JMdict.xml", "JMdict_e", "JMdict_e.xml":
 		return "edict", nil
 	case "JMnedict", "JMnedict.xml":
 		return "enamdict", nil
 	case "kanjidic2", "kanjidic2.xml":
 		return "kanjidic", nil
+	case "radkfile", "radkfile2", "radkfilex", "radkfilex.utf8":
+		return "radkfile", nil
 	case "CATALOGS":
 		return "epwing", nil
 	}
@@ -0,0 +1,174 @@
+/*
+ * Copyright (c) 2016 Alex Yatskov <alex@foosoft.net>
+ * Author: Alex Yatskov <alex@foosoft.net>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of
+ * this software and associated documentation files (the "Software"), to deal in
+ * the Software without restriction, including without limitation the rights to
+ * use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+ * the Software, and to permit persons to whom the Software is furnished to do so,
+ * subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+ * FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+ * COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+ * IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+ * CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/ulikunitz/xz"
+)
+
+func TestDetectFormat(t *testing.T) {
+	cases := map[string]string{
+		"JMdict_e":               "edict",
+		"JMdict_e.gz":            "edict",
+		"dl/JMnedict.xml.gz":     "enamdict",
+		"kanjidic2.xml.bz2":      "kanjidic",
+		"kanjidic2.xml.xz":       "kanjidic",
+		"JMdict.zip":             "edict",
+		"words.termfreq":         "termfreq",
+		"dl/rikai/dict.sqlite":   "rikai",
+		"dl/daijirin/CATALOGS":   "epwing",
+		"dl/kanjifreq.kanjifreq": "kanjifreq",
+	}
+
+	for path, expected := range cases {
+		if format, err := detectFormat(path); err != nil || format != expected {
+			t.Errorf("'%s' detected as '%s' (%v), expected '%s'", path, format, err, expected)
+		}
+	}
+
+	if _, err := detectFormat("-"); err == nil {
+		t.Error("expected stdin to require an explicit format")
+	}
+}
+
+func TestTermFilter(t *testing.T) {
+	terms := dbTermList{
+		{Expression: "仕事", DefinitionTags: []string{"n", "vs"}, TermTags: []string{"P", "ichi", "news"}},
+		{Expression: "心電図", DefinitionTags: []string{"n", "med"}, TermTags: []string{"spec"}},
+		{Expression: "御出で", DefinitionTags: []string{"n", "arch"}, TermTags: []string{"oK"}},
+	}
+
+	cases := []struct {
+		options  exportOptions
+		expected []string
+	}{
+		{options: exportOptions{}, expected: []string{"仕事", "心電図", "御出で"}},
+		{options: exportOptions{includeTags: "P"}, expected: []string{"仕事"}},
+		{options: exportOptions{includeTags: "spec, news+ichi"}, expected: []string{"仕事", "心電図"}},
+		{options: exportOptions{includeTags: "news+med"}},
+		{options: exportOptions{excludeTags: "arch,obs"}, expected: []string{"仕事", "心電図"}},
+		{options: exportOptions{fieldTags: "med,comp"}, expected: []string{"心電図"}},
+		{options: exportOptions{fieldTags: "spec"}},
+		{options: exportOptions{includeTags: "n", excludeTags: "P"}, expected: []string{"心電図", "御出で"}},
+	}
+
+	for _, c := range cases {
+		var expressions []string
+		for _, term := range terms.filter(makeTermFilter(c.options)) {
+			expressions = append(expressions, term.Expression)
+		}
+
+		if !reflect.DeepEqual(expressions, c.expected) {
+			t.Errorf("filter %+v kept %v, expected %v", c.options, expressions, c.expected)
+		}
+	}
+}
+
+func TestOpenInput(t *testing.T) {
+	dir, err := ioutil.TempDir("", "yomichan_test_")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	payload := []byte("<JMdict></JMdict>\n")
+
+	compress := map[string]func(io.Writer) (io.WriteCloser, error){
+		"plain": func(w io.Writer) (io.WriteCloser, error) {
+			return nopWriteCloser{w}, nil
+		},
+		"gzip": func(w io.Writer) (io.WriteCloser, error) {
+			return gzip.NewWriter(w), nil
+		},
+		"xz": func(w io.Writer) (io.WriteCloser, error) {
+			return xz.NewWriter(w)
+		},
+		"zip": func(w io.Writer) (io.WriteCloser, error) {
+			archive := zip.NewWriter(w)
+			file, err := archive.Create("JMdict.xml")
+			return zipEntryWriter{file, archive}, err
+		},
+	}
+
+	for name, writer := range compress {
+		t.Run(name, func(t *testing.T) {
+			var buffer bytes.Buffer
+			compressor, err := writer(&buffer)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := compressor.Write(payload); err != nil {
+				t.Fatal(err)
+			}
+			if err := compressor.Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			inputPath := filepath.Join(dir, name)
+			if err := ioutil.WriteFile(inputPath, buffer.Bytes(), 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			reader, err := openInput(inputPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer reader.Close()
+
+			data, err := ioutil.ReadAll(reader)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(data, payload) {
+				t.Errorf("read %q, expected %q", data, payload)
+			}
+		})
+	}
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error {
+	return nil
+}
+
+type zipEntryWriter struct {
+	io.Writer
+	archive *zip.Writer
+}
+
+func (w zipEntryWriter) Close() error {
+	return w.archive.Close()
+}
@@ -85,11 +85,12 @@ func (e *daijirinExtractor) extractTerms(entry epwingEntry, sequence int) []dbTe
 		for _, reading := range readings {
 			term := dbTerm{
 				Expression: reading,
-				Glossary:   []string{entry.Text},
+				Glossary:   []interface{}{entry.Text},
 				Sequence:   sequence,
 			}
 
 			e.exportRules(&term, tags)
+			epwingExportPartsOfSpeech(&term, tags)
 			terms = append(terms, term)
 		}
 
@@ -99,11 +100,12 @@ func (e *daijirinExtractor) extractTerms(entry epwingEntry, sequence int) []dbTe
 				term := dbTerm{
 					Expression: expression,
 					Reading:    reading,
-					Glossary:   []string{entry.Text},
+					Glossary:   []interface{}{entry.Text},
 					Sequence:   sequence,
 				}
 
 				e.exportRules(&term, tags)
+				epwingExportPartsOfSpeech(&term, tags)
 				terms = append(terms, term)
 			}
 		}
@@ -87,11 +87,12 @@ func (e *daijisenExtractor) extractTerms(entry epwingEntry, sequence int) []dbTe
 	if len(expressions) == 0 {
 		term := dbTerm{
 			Expression: reading,
-			Glossary:   []string{entry.Text},
+			Glossary:   []interface{}{entry.Text},
 			Sequence:   sequence,
 		}
 
 		e.exportRules(&term, tags)
+		epwingExportPartsOfSpeech(&term, tags)
 		terms = append(terms, term)
 
 	} else {
@@ -99,11 +100,12 @@ func (e *daijisenExtractor) extractTerms(entry epwingEntry, sequence int) []dbTe
 			term := dbTerm{
 				Expression: expression,
 				Reading:    reading,
-				Glossary:   []string{entry.Text},
+				Glossary:   []interface{}{entry.Text},
 				Sequence:   sequence,
 			}
 
 			e.exportRules(&term, tags)
+			epwingExportPartsOfSpeech(&term, tags)
 			terms = append(terms, term)
 		}
 	}
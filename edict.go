@@ -23,13 +23,258 @@
 package main
 
 import (
-	"os"
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"regexp"
+	"sort"
+	"strconv"
 	"strings"
 
 	"github.com/FooSoft/jmdict"
 )
 
-const jmdictRevision = "jmdict4"
+const jmdictRevision = "jmdict5"
+
+var jmdictCreatedExp = regexp.MustCompile(`<!-- JMdict created: (\S+) -->`)
+
+var jmdictLanguageNames = map[string]string{
+	"english":   "eng",
+	"dutch":     "dut",
+	"french":    "fre",
+	"german":    "ger",
+	"hungarian": "hun",
+	"italian":   "ita",
+	"russian":   "rus",
+	"slovenian": "slv",
+	"spanish":   "spa",
+	"swedish":   "swe",
+}
+
+var jmdictLanguageAliases = map[string]string{
+	"deu": "ger",
+	"fra": "fre",
+	"nld": "dut",
+}
+
+var jmdictNoteKinds = []string{"info", "xref", "ant", "source", "type"}
+
+var jmdictGlossaryTypeNames = map[string]string{
+	"lit":  "lit.",
+	"fig":  "fig.",
+	"expl": "expl.",
+	"tm":   "trademark",
+}
+
+const jmdictCanonicalPrefix = "→"
+
+var (
+	jmdictIrregularKanji    = []string{"sK", "iK", "oK", "io"}
+	jmdictIrregularReadings = []string{"sk", "ik", "ok"}
+)
+
+type jmdictOptions struct {
+	languages     []string
+	labels        bool
+	notes         map[string]bool
+	grouped       bool
+	irregular     string
+	filter        termFilter
+	glossaryTypes map[int][][]string
+}
+
+func jmdictParseNotes(value string) (map[string]bool, error) {
+	return parseKinds(value, "note", jmdictNoteKinds)
+}
+
+// The jmdict package does not decode the g_type attribute of glossaries, so
+// it is recovered here with a separate pass over the raw XML, keyed by entry
+// sequence, sense index and glossary index.
+func jmdictLoadGlossaryTypes(reader io.Reader, entities map[string]string) (map[int][][]string, error) {
+	decoder := xml.NewDecoder(reader)
+	decoder.Entity = entities
+
+	var (
+		types    = make(map[int][][]string)
+		sequence int
+		senses   [][]string
+		typed    bool
+	)
+
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+
+		switch element := token.(type) {
+		case xml.StartElement:
+			switch element.Name.Local {
+			case "entry":
+				sequence, senses, typed = 0, nil, false
+			case "ent_seq":
+				if err := decoder.DecodeElement(&sequence, &element); err != nil {
+					return nil, err
+				}
+			case "sense":
+				senses = append(senses, nil)
+			case "gloss":
+				if len(senses) == 0 {
+					continue
+				}
+
+				var glossaryType string
+				for _, attr := range element.Attr {
+					if attr.Name.Local == "g_type" {
+						glossaryType = attr.Value
+						typed = true
+					}
+				}
+
+				senses[len(senses)-1] = append(senses[len(senses)-1], glossaryType)
+			}
+		case xml.EndElement:
+			if element.Name.Local == "entry" && typed {
+				types[sequence] = senses
+			}
+		}
+	}
+
+	return types, nil
+}
+
+func jmdictLanguageName(code string) string {
+	for name, value := range jmdictLanguageNames {
+		if value == code {
+			return strings.Title(name)
+		}
+	}
+
+	return code
+}
+
+func jmdictBuildSource(source jmdict.JmdictSource) string {
+	text := "from " + jmdictLanguageName(jmdictGlossaryLanguage(source.Language))
+	if source.Type != nil && *source.Type == "part" {
+		text = "partly " + text
+	}
+	if source.Wasei == "y" {
+		text = "wasei, " + text
+	}
+	if len(source.Content) > 0 {
+		text += ": " + source.Content
+	}
+
+	return text
+}
+
+func jmdictBuildReferences(label string, references []string) dbStructuredContent {
+	content := []interface{}{label + ": "}
+	for index, reference := range references {
+		if index > 0 {
+			content = append(content, ", ")
+		}
+
+		target := strings.Split(reference, "・")[0]
+		content = append(content, makeQueryLink(target, reference))
+	}
+
+	return makeStructuredContent(content)
+}
+
+func jmdictExportNotes(term *dbTerm, sense jmdict.JmdictSense, notes map[string]bool) {
+	if notes["info"] {
+		for _, info := range sense.Information {
+			term.Glossary = append(term.Glossary, "("+info+")")
+		}
+	}
+
+	if notes["source"] {
+		for _, source := range sense.SourceLanguages {
+			term.Glossary = append(term.Glossary, jmdictBuildSource(source))
+		}
+	}
+
+	if notes["xref"] && len(sense.References) > 0 {
+		term.Glossary = append(term.Glossary, jmdictBuildReferences("see", sense.References))
+	}
+
+	if notes["ant"] && len(sense.Antonyms) > 0 {
+		term.Glossary = append(term.Glossary, jmdictBuildReferences("antonym", sense.Antonyms))
+	}
+}
+
+func jmdictParseLanguages(language string) ([]string, error) {
+	var codes []string
+	for _, name := range strings.Split(language, ",") {
+		name = strings.TrimSpace(name)
+		if len(name) == 0 {
+			continue
+		}
+
+		code, ok := jmdictLanguageNames[name]
+		if !ok {
+			code, ok = jmdictLanguageAliases[name]
+		}
+		if !ok {
+			if len(name) != 3 {
+				return nil, fmt.Errorf("unrecognized language '%s'", name)
+			}
+
+			code = name
+		}
+
+		codes = appendStringUnique(codes, code)
+	}
+
+	if len(codes) == 0 {
+		codes = append(codes, "eng")
+	}
+
+	return codes, nil
+}
+
+func jmdictCheckLanguages(codes []string, available map[string]bool) error {
+	for _, code := range codes {
+		if available[code] {
+			continue
+		}
+
+		var names []string
+		for name := range available {
+			names = append(names, name)
+		}
+
+		sort.Strings(names)
+		return fmt.Errorf("language '%s' is not present in dictionary (available: %s)", code, strings.Join(names, ", "))
+	}
+
+	return nil
+}
+
+func jmdictGlossaryLanguage(language *string) string {
+	if language == nil {
+		return "eng"
+	}
+
+	return *language
+}
+
+func jmdictExtractCreated(data []byte) string {
+	if index := bytes.Index(data, []byte("<entry>")); index >= 0 {
+		data = data[:index]
+	}
+
+	if matches := jmdictCreatedExp.FindSubmatch(data); matches != nil {
+		return string(matches[1])
+	}
+
+	return ""
+}
 
 func jmdictBuildRules(term *dbTerm) {
 	for _, tag := range term.DefinitionTags {
@@ -77,14 +322,93 @@ func jmdictAddPriorities(term *dbTerm, priorities ...string) {
 	}
 }
 
-func jmdictBuildTagMeta(entities map[string]string) dbTagList {
-	tags := dbTagList{
+func jmdictFrequencyBucket(priorities []string) int {
+	for _, priority := range priorities {
+		if !strings.HasPrefix(priority, "nf") {
+			continue
+		}
+
+		if bucket, err := strconv.Atoi(priority[2:]); err == nil {
+			return bucket
+		}
+	}
+
+	return 0
+}
+
+func jmdictExtractFrequencies(edictEntry jmdict.JmdictEntry, terms []dbTerm) dbMetaList {
+	var (
+		frequencies dbMetaList
+		visited     = make(map[[2]string]bool)
+	)
+
+	for _, term := range terms {
+		key := [2]string{term.Expression, term.Reading}
+		if visited[key] {
+			continue
+		}
+
+		visited[key] = true
+
+		var bucket int
+		for _, reading := range edictEntry.Readings {
+			if term.Reading == "" && reading.Reading == term.Expression {
+				bucket = jmdictFrequencyBucket(reading.Priorities)
+				break
+			}
+
+			if reading.Reading != term.Reading {
+				continue
+			}
+
+			for _, kanji := range edictEntry.Kanji {
+				if kanji.Expression != term.Expression {
+					continue
+				}
+
+				var shared []string
+				for _, priority := range kanji.Priorities {
+					if hasString(priority, reading.Priorities) {
+						shared = append(shared, priority)
+					}
+				}
+
+				bucket = jmdictFrequencyBucket(shared)
+			}
+		}
+
+		if bucket == 0 {
+			continue
+		}
+
+		frequency := map[string]interface{}{
+			"value":        bucket,
+			"displayValue": fmt.Sprintf("nf%02d", bucket),
+		}
+
+		var data interface{} = frequency
+		if term.Reading != "" {
+			data = map[string]interface{}{"reading": term.Reading, "frequency": frequency}
+		}
+
+		frequencies = append(frequencies, dbMeta{term.Expression, "freq", data})
+	}
+
+	return frequencies
+}
+
+func jmdictBuildPriorityTagMeta() dbTagList {
+	return dbTagList{
 		dbTag{Name: "news", Notes: "appears frequently in Mainichi Shimbun", Category: "frequent", Order: -2},
 		dbTag{Name: "ichi", Notes: "listed as common in Ichimango Goi Bunruishuu", Category: "frequent", Order: -2},
 		dbTag{Name: "spec", Notes: "common words not included in frequency lists", Category: "frequent", Order: -2},
 		dbTag{Name: "gai", Notes: "common loanword", Category: "frequent", Order: -2},
 		dbTag{Name: "P", Notes: "popular term", Category: "popular", Order: -10, Score: 10},
 	}
+}
+
+func jmdictBuildTagMeta(entities map[string]string) dbTagList {
+	tags := jmdictBuildPriorityTagMeta()
 
 	for name, value := range entities {
 		tag := dbTag{Name: name, Notes: value}
@@ -114,14 +438,129 @@ func jmdictBuildTagMeta(entities map[string]string) dbTagList {
 	return tags
 }
 
-func jmdictExtractTerms(edictEntry jmdict.JmdictEntry, language string) []dbTerm {
-	var terms []dbTerm
+func jmdictGroupSenses(senseTerms []dbTerm) dbTerm {
+	term := dbTerm{
+		Expression: senseTerms[0].Expression,
+		Reading:    senseTerms[0].Reading,
+		TermTags:   senseTerms[0].TermTags,
+		Score:      senseTerms[0].Score,
+		Sequence:   senseTerms[0].Sequence,
+	}
+
+	var items []interface{}
+	for _, senseTerm := range senseTerms {
+		var content []interface{}
+		if len(senseTerm.DefinitionTags) > 0 {
+			content = append(content, "("+strings.Join(senseTerm.DefinitionTags, ", ")+") ")
+		}
+
+		for index, glossary := range senseTerm.Glossary {
+			if index > 0 {
+				content = append(content, "; ")
+			}
+
+			if structured, ok := glossary.(dbStructuredContent); ok {
+				content = append(content, structured.Content)
+			} else {
+				content = append(content, glossary)
+			}
+		}
+
+		items = append(items, dbContentNode{Tag: "li", Content: content})
+
+		term.addRules(senseTerm.Rules...)
+		if senseTerm.Score > term.Score {
+			term.Score = senseTerm.Score
+		}
+	}
+
+	term.Glossary = []interface{}{makeStructuredContent(dbContentNode{Tag: "ol", Content: items})}
+	return term
+}
+
+func jmdictParseIrregularForms(value string) (string, error) {
+	switch value {
+	case "", "keep":
+		return "keep", nil
+	case "secondary", "exclude":
+		return value, nil
+	default:
+		return "", fmt.Errorf("unrecognized irregular form handling '%s' (expected keep, secondary or exclude)", value)
+	}
+}
+
+func jmdictIsIrregular(information, irregular []string) bool {
+	for _, info := range information {
+		if hasString(info, irregular) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func jmdictBuildFormTagMeta(terms dbTermList) dbTagList {
+	var (
+		tags    dbTagList
+		visited = make(map[string]bool)
+	)
+
+	for _, term := range terms {
+		for _, tag := range term.TermTags {
+			if !strings.HasPrefix(tag, jmdictCanonicalPrefix) || visited[tag] {
+				continue
+			}
+
+			visited[tag] = true
+			tags = append(tags, dbTag{
+				Name:     tag,
+				Notes:    "irregular or search-only form of " + strings.TrimPrefix(tag, jmdictCanonicalPrefix),
+				Category: "form",
+				Score:    -5,
+			})
+		}
+	}
+
+	return tags
+}
+
+func jmdictExtractTerms(edictEntry jmdict.JmdictEntry, options jmdictOptions) []dbTerm {
+	var (
+		terms            []dbTerm
+		canonicalKanji   string
+		canonicalReading string
+	)
+
+	for _, kanji := range edictEntry.Kanji {
+		if !jmdictIsIrregular(kanji.Information, jmdictIrregularKanji) {
+			canonicalKanji = kanji.Expression
+			break
+		}
+	}
+
+	for _, reading := range edictEntry.Readings {
+		if !jmdictIsIrregular(reading.Information, jmdictIrregularReadings) {
+			canonicalReading = reading.Reading
+			break
+		}
+	}
 
 	convert := func(reading jmdict.JmdictReading, kanji *jmdict.JmdictKanji) {
 		if kanji != nil && reading.Restrictions != nil && !hasString(kanji.Expression, reading.Restrictions) {
 			return
 		}
 
+		var canonical string
+		if kanji != nil && canonicalKanji != "" && jmdictIsIrregular(kanji.Information, jmdictIrregularKanji) {
+			canonical = canonicalKanji
+		} else if canonicalReading != "" && jmdictIsIrregular(reading.Information, jmdictIrregularReadings) {
+			canonical = canonicalReading
+		}
+
+		if canonical != "" && options.irregular == "exclude" {
+			return
+		}
+
 		var termBase dbTerm
 		termBase.addTermTags(reading.Information...)
 
@@ -140,7 +579,11 @@ func jmdictExtractTerms(edictEntry jmdict.JmdictEntry, language string) []dbTerm
 			}
 		}
 
-		var partsOfSpeech []string
+		var (
+			partsOfSpeech []string
+			senseTerms    []dbTerm
+		)
+
 		for index, sense := range edictEntry.Sense {
 
 			if len(sense.PartsOfSpeech) != 0 {
@@ -162,9 +605,32 @@ func jmdictExtractTerms(edictEntry jmdict.JmdictEntry, language string) []dbTerm
 				Sequence:   edictEntry.Sequence,
 			}
 
-			for _, glossary := range sense.Glossary {
-				if glossary.Language == nil && language == "" || glossary.Language != nil && language == *glossary.Language {
-					term.Glossary = append(term.Glossary, glossary.Content)
+			var glossaryTypes []string
+			if senses, ok := options.glossaryTypes[edictEntry.Sequence]; ok && index < len(senses) {
+				glossaryTypes = senses[index]
+			}
+
+			for _, language := range options.languages {
+				for glossaryIndex, glossary := range sense.Glossary {
+					if jmdictGlossaryLanguage(glossary.Language) != language {
+						continue
+					}
+
+					content := glossary.Content
+					if glossaryIndex < len(glossaryTypes) && len(glossaryTypes[glossaryIndex]) > 0 {
+						glossaryType := glossaryTypes[glossaryIndex]
+						if name, ok := jmdictGlossaryTypeNames[glossaryType]; ok {
+							glossaryType = name
+						}
+
+						content = fmt.Sprintf("(%s) %s", glossaryType, content)
+					}
+
+					if options.labels {
+						term.Glossary = append(term.Glossary, fmt.Sprintf("[%s] %s", language, content))
+					} else {
+						term.Glossary = append(term.Glossary, content)
+					}
 				}
 			}
 
@@ -172,6 +638,8 @@ func jmdictExtractTerms(edictEntry jmdict.JmdictEntry, language string) []dbTerm
 				continue
 			}
 
+			jmdictExportNotes(&term, sense, options.notes)
+
 			term.addDefinitionTags(termBase.DefinitionTags...)
 			term.addTermTags(termBase.TermTags...)
 			term.addDefinitionTags(partsOfSpeech...)
@@ -182,7 +650,20 @@ func jmdictExtractTerms(edictEntry jmdict.JmdictEntry, language string) []dbTerm
 			jmdictBuildRules(&term)
 			jmdictBuildScore(&term)
 
-			terms = append(terms, term)
+			if canonical != "" && options.irregular == "secondary" {
+				term.addTermTags(jmdictCanonicalPrefix + canonical)
+				term.Score -= 1000
+			}
+
+			if options.filter.matchesFields(term, sense.Fields) {
+				senseTerms = append(senseTerms, term)
+			}
+		}
+
+		if options.grouped && len(senseTerms) > 1 {
+			terms = append(terms, jmdictGroupSenses(senseTerms))
+		} else {
+			terms = append(terms, senseTerms...)
 		}
 	}
 
@@ -208,43 +689,78 @@ func jmdictExtractTerms(edictEntry jmdict.JmdictEntry, language string) []dbTerm
 	return terms
 }
 
-func jmdictExportDb(inputPath, outputPath, language, title string, stride int, pretty bool) error {
-	reader, err := os.Open(inputPath)
+func jmdictExportDb(inputPath, outputPath, language, title string, stride int, pretty bool, options exportOptions) error {
+	reader, err := openInput(inputPath)
 	if err != nil {
 		return err
 	}
 	defer reader.Close()
 
-	dict, entities, err := jmdict.LoadJmdictNoTransform(reader)
+	data, err := ioutil.ReadAll(reader)
+	if err != nil {
+		return err
+	}
+
+	dict, entities, err := jmdict.LoadJmdictNoTransform(bytes.NewReader(data))
 	if err != nil {
 		return err
 	}
 
-	var langTag string
-	switch language {
-	case "dutch":
-		langTag = "dut"
-	case "french":
-		langTag = "fre"
-	case "german":
-		langTag = "ger"
-	case "hungarian":
-		langTag = "hun"
-	case "italian":
-		langTag = "ita"
-	case "russian":
-		langTag = "rus"
-	case "slovenian":
-		langTag = "slv"
-	case "spanish":
-		langTag = "spa"
-	case "swedish":
-		langTag = "swe"
-	}
-
-	var terms dbTermList
+	languages, err := jmdictParseLanguages(language)
+	if err != nil {
+		return err
+	}
+
+	available := make(map[string]bool)
 	for _, entry := range dict.Entries {
-		terms = append(terms, jmdictExtractTerms(entry, langTag)...)
+		for _, sense := range entry.Sense {
+			for _, glossary := range sense.Glossary {
+				available[jmdictGlossaryLanguage(glossary.Language)] = true
+			}
+		}
+	}
+
+	if err := jmdictCheckLanguages(languages, available); err != nil {
+		return err
+	}
+
+	notes, err := jmdictParseNotes(options.jmdictNotes)
+	if err != nil {
+		return err
+	}
+
+	irregular, err := jmdictParseIrregularForms(options.irregularForms)
+	if err != nil {
+		return err
+	}
+
+	jmdictOpts := jmdictOptions{
+		languages: languages,
+		labels:    options.languageLabels,
+		notes:     notes,
+		grouped:   options.groupSenses,
+		irregular: irregular,
+		filter:    makeTermFilter(options),
+	}
+
+	if notes["type"] {
+		if jmdictOpts.glossaryTypes, err = jmdictLoadGlossaryTypes(bytes.NewReader(data), entities); err != nil {
+			return err
+		}
+	}
+
+	var (
+		terms       dbTermList
+		frequencies dbMetaList
+	)
+
+	for _, entry := range dict.Entries {
+		entryTerms := jmdictExtractTerms(entry, jmdictOpts)
+		terms = append(terms, entryTerms...)
+
+		if options.jmdictFrequency {
+			frequencies = append(frequencies, jmdictExtractFrequencies(entry, entryTerms)...)
+		}
 	}
 
 	if title == "" {
@@ -253,15 +769,28 @@ func jmdictExportDb(inputPath, outputPath, language, title string, stride int, p
 
 	recordData := map[string]dbRecordList{
 		"term": terms.crush(),
-		"tag":  jmdictBuildTagMeta(entities).crush(),
+		"tag":  append(jmdictBuildTagMeta(entities), jmdictBuildFormTagMeta(terms)...).crush(),
+	}
+
+	if options.jmdictFrequency {
+		recordData["term_meta"] = frequencies.crush()
+	}
+
+	revision := jmdictRevision
+	var description string
+	if created := jmdictExtractCreated(data); created != "" {
+		revision += "." + created
+		description = fmt.Sprintf("JMdict created %s, converted with format %s", created, jmdictRevision)
 	}
 
 	return writeDb(
 		outputPath,
 		title,
-		jmdictRevision,
+		revision,
+		description,
 		true,
 		recordData,
+		nil,
 		stride,
 		pretty,
 	)
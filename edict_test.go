@@ -0,0 +1,368 @@
+/*
+ * Copyright (c) 2016 Alex Yatskov <alex@foosoft.net>
+ * Author: Alex Yatskov <alex@foosoft.net>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of
+ * this software and associated documentation files (the "Software"), to deal in
+ * the Software without restriction, including without limitation the rights to
+ * use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+ * the Software, and to permit persons to whom the Software is furnished to do so,
+ * subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+ * FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+ * COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+ * IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+ * CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/FooSoft/jmdict"
+)
+
+func loadJmdictFixture(t *testing.T) (jmdict.Jmdict, map[string]string) {
+	t.Helper()
+
+	reader, err := os.Open(filepath.Join("testdata", "jmdict", "JMdict.xml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reader.Close()
+
+	dict, entities, err := jmdict.LoadJmdictNoTransform(reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return dict, entities
+}
+
+func findJmdictEntry(t *testing.T, dict jmdict.Jmdict, sequence int) jmdict.JmdictEntry {
+	t.Helper()
+
+	for _, entry := range dict.Entries {
+		if entry.Sequence == sequence {
+			return entry
+		}
+	}
+
+	t.Fatalf("entry %d not found", sequence)
+	return jmdict.JmdictEntry{}
+}
+
+func TestJmdictParseLanguages(t *testing.T) {
+	cases := []struct {
+		input    string
+		expected []string
+		fails    bool
+	}{
+		{input: "", expected: []string{"eng"}},
+		{input: "english", expected: []string{"eng"}},
+		{input: "german", expected: []string{"ger"}},
+		{input: "eng, ger,fre", expected: []string{"eng", "ger", "fre"}},
+		{input: "deu,ger", expected: []string{"ger"}},
+		{input: "klingon", fails: true},
+	}
+
+	for _, c := range cases {
+		languages, err := jmdictParseLanguages(c.input)
+		if c.fails {
+			if err == nil {
+				t.Errorf("expected '%s' to fail", c.input)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("unexpected error for '%s': %s", c.input, err.Error())
+		} else if !reflect.DeepEqual(languages, c.expected) {
+			t.Errorf("'%s' parsed as %v, expected %v", c.input, languages, c.expected)
+		}
+	}
+}
+
+func TestJmdictLanguageName(t *testing.T) {
+	for code, expected := range map[string]string{"ger": "German", "dut": "Dutch", "fre": "French", "por": "por"} {
+		if name := jmdictLanguageName(code); name != expected {
+			t.Errorf("'%s' named %s, expected %s", code, name, expected)
+		}
+	}
+}
+
+func TestJmdictRestrictions(t *testing.T) {
+	dict, _ := loadJmdictFixture(t)
+	entry := findJmdictEntry(t, dict, 1000004)
+
+	var senses []string
+	for _, term := range jmdictExtractTerms(entry, jmdictOptions{languages: []string{"eng"}}) {
+		senses = append(senses, fmt.Sprintf("%s/%s: %s", term.Expression, term.Reading, term.Glossary[0]))
+	}
+
+	expected := []string{
+		"御出で/おいで: going (archaic)",
+		"お出で/おいで: coming",
+		"お出で/おいで: going (archaic)",
+		"お出で/おいでー: coming",
+		"オイデ/: coming",
+	}
+
+	if !reflect.DeepEqual(senses, expected) {
+		t.Errorf("got senses %q, expected %q", senses, expected)
+	}
+}
+
+func TestJmdictExtractLanguages(t *testing.T) {
+	dict, _ := loadJmdictFixture(t)
+	entry := findJmdictEntry(t, dict, 1000001)
+
+	terms := jmdictExtractTerms(entry, jmdictOptions{languages: []string{"ger", "eng"}, labels: true})
+	if len(terms) != 2 {
+		t.Fatalf("expected 2 terms, got %d", len(terms))
+	}
+
+	expected := []interface{}{"[ger] Arbeit", "[eng] work", "[eng] job"}
+	if !reflect.DeepEqual(terms[0].Glossary, expected) {
+		t.Errorf("got glossary %v, expected %v", terms[0].Glossary, expected)
+	}
+
+	terms = jmdictExtractTerms(entry, jmdictOptions{languages: []string{"fre"}})
+	if len(terms) != 1 || !reflect.DeepEqual(terms[0].Glossary, []interface{}{"travail"}) {
+		t.Errorf("unexpected French terms %v", terms)
+	}
+}
+
+func TestJmdictExtractNotes(t *testing.T) {
+	dict, entities := loadJmdictFixture(t)
+
+	reader, err := os.Open(filepath.Join("testdata", "jmdict", "JMdict.xml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reader.Close()
+
+	glossaryTypes, err := jmdictLoadGlossaryTypes(reader, entities)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	notes, err := jmdictParseNotes("all")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	options := jmdictOptions{languages: []string{"eng"}, notes: notes, glossaryTypes: glossaryTypes}
+
+	cases := []struct {
+		sequence int
+		sense    int
+		expected []interface{}
+	}{
+		{
+			sequence: 1000001,
+			sense:    0,
+			expected: []interface{}{"work", "job", makeStructuredContent([]interface{}{"see: ", makeQueryLink("職業", "職業")})},
+		},
+		{
+			sequence: 1000001,
+			sense:    1,
+			expected: []interface{}{"work (physics)", "(physics term)"},
+		},
+		{
+			sequence: 1000002,
+			sense:    0,
+			expected: []interface{}{"to understand", "to comprehend", makeStructuredContent([]interface{}{"antonym: ", makeQueryLink("知らない", "知らない")})},
+		},
+		{
+			sequence: 1000003,
+			sense:    0,
+			expected: []interface{}{"part-time job", "from German: Arbeit"},
+		},
+		{
+			sequence: 1000005,
+			sense:    0,
+			expected: []interface{}{"electrocardiogram", "ECG", "(expl.) recording of the electrical activity of the heart"},
+		},
+	}
+
+	for _, c := range cases {
+		terms := jmdictExtractTerms(findJmdictEntry(t, dict, c.sequence), options)
+		if c.sense >= len(terms) {
+			t.Errorf("entry %d has no sense %d", c.sequence, c.sense)
+		} else if !reflect.DeepEqual(terms[c.sense].Glossary, c.expected) {
+			t.Errorf("entry %d sense %d has glossary %v, expected %v", c.sequence, c.sense, terms[c.sense].Glossary, c.expected)
+		}
+	}
+
+	if _, err := jmdictParseNotes("info,bogus"); err == nil {
+		t.Error("expected an unknown note kind to fail")
+	}
+
+	for _, value := range []string{"", "none"} {
+		if notes, err := jmdictParseNotes(value); err != nil || len(notes) > 0 {
+			t.Errorf("expected '%s' to select no notes, got %v (%v)", value, notes, err)
+		}
+	}
+}
+
+func TestJmdictGroupSenses(t *testing.T) {
+	dict, _ := loadJmdictFixture(t)
+	entry := findJmdictEntry(t, dict, 1000001)
+
+	options := jmdictOptions{languages: []string{"eng"}}
+	senseTerms := jmdictExtractTerms(entry, options)
+
+	options.grouped = true
+	terms := jmdictExtractTerms(entry, options)
+	if len(terms) != 1 {
+		t.Fatalf("expected 1 grouped term, got %d", len(terms))
+	}
+
+	term := terms[0]
+	if term.Score != senseTerms[0].Score || !reflect.DeepEqual(term.Rules, []string{"vs"}) || len(term.DefinitionTags) != 0 {
+		t.Errorf("unexpected grouped term %+v", term)
+	}
+
+	expected := []interface{}{makeStructuredContent(dbContentNode{
+		Tag: "ol",
+		Content: []interface{}{
+			dbContentNode{Tag: "li", Content: []interface{}{"(n, vs) ", "work", "; ", "job"}},
+			dbContentNode{Tag: "li", Content: []interface{}{"(n) ", "work (physics)"}},
+		},
+	})}
+
+	if !reflect.DeepEqual(term.Glossary, expected) {
+		t.Errorf("got glossary %+v, expected %+v", term.Glossary, expected)
+	}
+}
+
+func TestJmdictExtractFrequencies(t *testing.T) {
+	dict, _ := loadJmdictFixture(t)
+	options := jmdictOptions{languages: []string{"eng"}}
+
+	entry := findJmdictEntry(t, dict, 1000002)
+	frequencies := jmdictExtractFrequencies(entry, jmdictExtractTerms(entry, options))
+
+	expected := dbMetaList{
+		dbMeta{"分かる", "freq", map[string]interface{}{
+			"reading":   "わかる",
+			"frequency": map[string]interface{}{"value": 14, "displayValue": "nf14"},
+		}},
+	}
+
+	if !reflect.DeepEqual(frequencies, expected) {
+		t.Errorf("got frequencies %v, expected %v", frequencies, expected)
+	}
+
+	entry = findJmdictEntry(t, dict, 1000004)
+	if frequencies := jmdictExtractFrequencies(entry, jmdictExtractTerms(entry, options)); len(frequencies) != 0 {
+		t.Errorf("expected no frequencies for an entry without nf priorities, got %v", frequencies)
+	}
+}
+
+func TestJmdictIrregularForms(t *testing.T) {
+	dict, _ := loadJmdictFixture(t)
+
+	headwords := func(terms []dbTerm) []string {
+		var results []string
+		for _, term := range terms {
+			results = append(results, term.Expression+"/"+term.Reading)
+		}
+		return results
+	}
+
+	entry := findJmdictEntry(t, dict, 1000002)
+
+	terms := jmdictExtractTerms(entry, jmdictOptions{languages: []string{"eng"}, irregular: "exclude"})
+	if expected := []string{"分かる/わかる", "解る/わかる"}; !reflect.DeepEqual(headwords(terms), expected) {
+		t.Errorf("got headwords %v, expected %v", headwords(terms), expected)
+	}
+
+	terms = jmdictExtractTerms(entry, jmdictOptions{languages: []string{"eng"}, irregular: "secondary"})
+	if len(terms) != 3 || !hasString("→分かる", terms[2].TermTags) || terms[2].Score >= terms[1].Score {
+		t.Errorf("expected 判る to be a secondary form of 分かる, got %+v", terms)
+	}
+
+	tags := jmdictBuildFormTagMeta(terms)
+	if len(tags) != 1 || tags[0].Name != "→分かる" {
+		t.Errorf("unexpected form tags %+v", tags)
+	}
+
+	entry = findJmdictEntry(t, dict, 1000003)
+	terms = jmdictExtractTerms(entry, jmdictOptions{languages: []string{"eng"}, irregular: "exclude"})
+	if expected := []string{"アルバイト/アルバイト"}; !reflect.DeepEqual(headwords(terms), expected) {
+		t.Errorf("got headwords %v, expected %v", headwords(terms), expected)
+	}
+}
+
+func TestJmdictExtractCreated(t *testing.T) {
+	data, err := ioutil.ReadFile(filepath.Join("testdata", "jmdict", "JMdict.xml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if created := jmdictExtractCreated(data); created != "2026-10-01" {
+		t.Errorf("unexpected creation date '%s'", created)
+	}
+
+	if created := jmdictExtractCreated([]byte("<JMdict><entry><!-- JMdict created: 2000-01-01 --></entry></JMdict>")); created != "" {
+		t.Errorf("comments inside entries should be ignored, got '%s'", created)
+	}
+}
+
+func TestJmdictFilterSenses(t *testing.T) {
+	dict, _ := loadJmdictFixture(t)
+	entry := findJmdictEntry(t, dict, 1000004)
+
+	options := jmdictOptions{languages: []string{"eng"}, filter: makeTermFilter(exportOptions{excludeTags: "arch"})}
+	for _, term := range jmdictExtractTerms(entry, options) {
+		if hasString("arch", term.DefinitionTags) {
+			t.Errorf("archaic sense was not filtered from %s", term.Expression)
+		}
+	}
+
+	options.filter = makeTermFilter(exportOptions{fieldTags: "med"})
+	for _, entry := range dict.Entries {
+		for _, term := range jmdictExtractTerms(entry, options) {
+			if term.Expression != "心電図" {
+				t.Errorf("unexpected term %s for field filter", term.Expression)
+			}
+		}
+	}
+
+	options.filter = makeTermFilter(exportOptions{fieldTags: "n,arch"})
+	for _, entry := range dict.Entries {
+		if terms := jmdictExtractTerms(entry, options); len(terms) > 0 {
+			t.Errorf("field filter matched non-field tags of %s", terms[0].Expression)
+		}
+	}
+}
+
+func TestJmdictExportUnknownLanguage(t *testing.T) {
+	dir, err := ioutil.TempDir("", "yomichan_test_")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	inputPath := filepath.Join("testdata", "jmdict", "JMdict.xml")
+	outputPath := filepath.Join(dir, "unused.zip")
+
+	err = jmdictExportDb(inputPath, outputPath, "por", "", defaultStride, false, exportOptions{})
+	if err == nil || !strings.Contains(err.Error(), "not present") {
+		t.Errorf("expected missing language error, got %v", err)
+	}
+}
@@ -23,23 +23,56 @@
 package main
 
 import (
-	"os"
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"sort"
+	"strings"
 
 	"github.com/FooSoft/jmdict"
 )
 
-const jmnedictRevision = "jmnedict1"
+const jmnedictRevision = "jmnedict2"
+
+var jmnedictNameTypeScores = map[string]int{
+	"surname": 30,
+	"given":   20,
+	"fem":     20,
+	"masc":    20,
+	"place":   20,
+	"person":  10,
+	"station": 10,
+}
+
+func jmnedictBuildScore(term *dbTerm) {
+	jmdictBuildScore(term)
+
+	var typeScore int
+	for _, nameType := range term.DefinitionTags {
+		if score := jmnedictNameTypeScores[nameType]; score > typeScore {
+			typeScore = score
+		}
+	}
+
+	term.Score += typeScore
+}
 
 func jmnedictBuildTagMeta(entities map[string]string) dbTagList {
-	var tags dbTagList
+	tags := jmdictBuildPriorityTagMeta()
 
 	for name, value := range entities {
 		tag := dbTag{Name: name, Notes: value}
 
 		switch name {
-		case "company", "fem", "given", "masc", "organization", "person", "place", "product", "station", "surname", "unclass", "work":
+		case "char", "company", "creat", "dei", "doc", "ev", "fem", "fict", "given", "group", "leg", "masc", "myth", "obj", "organization",
+			"oth", "person", "place", "product", "relig", "serv", "ship", "station", "surname", "unclass", "work":
 			tag.Category = "name"
 			tag.Order = 4
+		case "ok", "oik", "ik", "iK", "oK", "io":
+			tag.Score = -5
 		}
 
 		tags = append(tags, tag)
@@ -48,11 +81,132 @@ func jmnedictBuildTagMeta(entities map[string]string) dbTagList {
 	return tags
 }
 
-func jmnedictExtractTerms(enamdictEntry jmdict.JmnedictEntry) []dbTerm {
+type jmnedictOptions struct {
+	languages            []string
+	labels               bool
+	nameTypes            []string
+	excludeNameTypes     []string
+	translationLanguages map[int][][]string
+}
+
+// The jmdict package looks for xml:lang on trans, but the JMnedict DTD puts
+// it on trans_det, so translation languages are recovered here with a
+// separate pass over the raw XML, keyed by entry sequence, translation index
+// and detail index. Entries with only English details are left out.
+func jmnedictLoadTranslationLanguages(reader io.Reader, entities map[string]string) (map[int][][]string, error) {
+	decoder := xml.NewDecoder(reader)
+	decoder.Entity = entities
+
+	var (
+		languages    = make(map[int][][]string)
+		sequence     int
+		translations [][]string
+		foreign      bool
+	)
+
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+
+		switch element := token.(type) {
+		case xml.StartElement:
+			switch element.Name.Local {
+			case "entry":
+				sequence, translations, foreign = 0, nil, false
+			case "ent_seq":
+				if err := decoder.DecodeElement(&sequence, &element); err != nil {
+					return nil, err
+				}
+			case "trans":
+				translations = append(translations, nil)
+			case "trans_det":
+				if len(translations) == 0 {
+					continue
+				}
+
+				language := "eng"
+				for _, attr := range element.Attr {
+					if attr.Name.Local == "lang" {
+						language = attr.Value
+					}
+				}
+				if language != "eng" {
+					foreign = true
+				}
+
+				translations[len(translations)-1] = append(translations[len(translations)-1], language)
+			}
+		case xml.EndElement:
+			if element.Name.Local == "entry" && foreign {
+				languages[sequence] = translations
+			}
+		}
+	}
+
+	return languages, nil
+}
+
+func jmnedictTranslationLanguage(languages map[int][][]string, sequence, trans, detail int) string {
+	if translations, ok := languages[sequence]; ok && trans < len(translations) && detail < len(translations[trans]) {
+		return translations[trans][detail]
+	}
+
+	return "eng"
+}
+
+func jmnedictParseNameTypes(value string, available map[string]bool) ([]string, error) {
+	var nameTypes []string
+	for _, nameType := range strings.Split(value, ",") {
+		nameType = strings.TrimSpace(nameType)
+		if len(nameType) == 0 {
+			continue
+		}
+
+		if !available[nameType] {
+			var names []string
+			for name := range available {
+				names = append(names, name)
+			}
+
+			sort.Strings(names)
+			return nil, fmt.Errorf("name type '%s' is not present in dictionary (available: %s)", nameType, strings.Join(names, ", "))
+		}
+
+		nameTypes = appendStringUnique(nameTypes, nameType)
+	}
+
+	return nameTypes, nil
+}
+
+func jmnedictMatchNameTypes(trans jmdict.JmnedictTranslation, options jmnedictOptions) bool {
+	for _, nameType := range trans.NameTypes {
+		if hasString(nameType, options.excludeNameTypes) {
+			return false
+		}
+	}
+
+	if len(options.nameTypes) == 0 {
+		return true
+	}
+
+	for _, nameType := range trans.NameTypes {
+		if hasString(nameType, options.nameTypes) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func jmnedictExtractTerms(enamdictEntry jmdict.JmnedictEntry, options jmnedictOptions) []dbTerm {
 	var terms []dbTerm
 
 	convert := func(reading jmdict.JmnedictReading, kanji *jmdict.JmnedictKanji) {
-		if kanji != nil && hasString(kanji.Expression, reading.Restrictions) {
+		if kanji != nil && reading.Restrictions != nil && !hasString(kanji.Expression, reading.Restrictions) {
 			return
 		}
 
@@ -62,6 +216,7 @@ func jmnedictExtractTerms(enamdictEntry jmdict.JmnedictEntry) []dbTerm {
 
 		if kanji == nil {
 			term.Expression = reading.Reading
+			jmdictAddPriorities(&term, reading.Priorities...)
 		} else {
 			term.Expression = kanji.Expression
 			term.Reading = reading.Reading
@@ -69,19 +224,45 @@ func jmnedictExtractTerms(enamdictEntry jmdict.JmnedictEntry) []dbTerm {
 
 			for _, priority := range kanji.Priorities {
 				if hasString(priority, reading.Priorities) {
-					term.addTermTags(priority)
+					jmdictAddPriorities(&term, priority)
 				}
 			}
 		}
 
-		for _, trans := range enamdictEntry.Translations {
-			term.Glossary = append(term.Glossary, trans.Translations...)
-			term.addDefinitionTags(trans.NameTypes...)
+		for _, language := range options.languages {
+			for transIndex, trans := range enamdictEntry.Translations {
+				if !jmnedictMatchNameTypes(trans, options) {
+					continue
+				}
+
+				var matched bool
+				for detailIndex, translation := range trans.Translations {
+					if jmnedictTranslationLanguage(options.translationLanguages, enamdictEntry.Sequence, transIndex, detailIndex) != language {
+						continue
+					}
+
+					matched = true
+					if options.labels {
+						term.Glossary = append(term.Glossary, fmt.Sprintf("[%s] %s", language, translation))
+					} else {
+						term.Glossary = append(term.Glossary, translation)
+					}
+				}
+
+				if matched {
+					term.addDefinitionTags(trans.NameTypes...)
+				}
+			}
 		}
 
-		terms = append(terms, term)
+		if len(term.Glossary) > 0 {
+			jmnedictBuildScore(&term)
+			terms = append(terms, term)
+		}
 	}
 
+	// Unlike JMdict, the JMnedict DTD has no re_nokanji element, so every
+	// reading of an entry with kanji is paired with the kanji it applies to.
 	if len(enamdictEntry.Kanji) > 0 {
 		for _, kanji := range enamdictEntry.Kanji {
 			for _, reading := range enamdictEntry.Readings {
@@ -97,38 +278,138 @@ func jmnedictExtractTerms(enamdictEntry jmdict.JmnedictEntry) []dbTerm {
 	return terms
 }
 
-func jmnedictExportDb(inputPath, outputPath, language, title string, stride int, pretty bool) error {
-	reader, err := os.Open(inputPath)
+func jmnedictExportDb(inputPath, outputPath, language, title string, stride int, pretty bool, options exportOptions) error {
+	reader, err := openInput(inputPath)
 	if err != nil {
 		return err
 	}
 	defer reader.Close()
 
-	dict, entities, err := jmdict.LoadJmnedictNoTransform(reader)
+	data, err := ioutil.ReadAll(reader)
+	if err != nil {
+		return err
+	}
+
+	dict, entities, err := jmdict.LoadJmnedictNoTransform(bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+
+	translationLanguages, err := jmnedictLoadTranslationLanguages(bytes.NewReader(data), entities)
+	if err != nil {
+		return err
+	}
+
+	languages, err := jmdictParseLanguages(language)
 	if err != nil {
 		return err
 	}
 
-	var terms dbTermList
+	var (
+		availableLanguages = make(map[string]bool)
+		available          = make(map[string]bool)
+	)
+
 	for _, entry := range dict.Entries {
-		terms = append(terms, jmnedictExtractTerms(entry)...)
+		for transIndex, trans := range entry.Translations {
+			for detailIndex := range trans.Translations {
+				availableLanguages[jmnedictTranslationLanguage(translationLanguages, entry.Sequence, transIndex, detailIndex)] = true
+			}
+			for _, nameType := range trans.NameTypes {
+				available[nameType] = true
+			}
+		}
+	}
+
+	if err := jmdictCheckLanguages(languages, availableLanguages); err != nil {
+		return err
+	}
+
+	jmnedictOpts := jmnedictOptions{
+		languages:            languages,
+		labels:               options.languageLabels,
+		translationLanguages: translationLanguages,
+	}
+
+	if jmnedictOpts.nameTypes, err = jmnedictParseNameTypes(options.nameTypes, available); err != nil {
+		return err
+	}
+	if jmnedictOpts.excludeNameTypes, err = jmnedictParseNameTypes(options.excludeNameTypes, available); err != nil {
+		return err
 	}
 
 	if title == "" {
 		title = "JMnedict"
 	}
 
+	filter := makeTermFilter(options)
+	tags := jmnedictBuildTagMeta(entities)
+
+	if !options.splitNameTypes {
+		var terms dbTermList
+		for _, entry := range dict.Entries {
+			terms = append(terms, jmnedictExtractTerms(entry, jmnedictOpts)...)
+		}
+
+		return jmnedictWriteDb(outputPath, title, terms.filter(filter), tags, stride, pretty)
+	}
+
+	nameTerms := make(map[string]dbTermList)
+	for _, entry := range dict.Entries {
+		var nameTypes []string
+		for _, trans := range entry.Translations {
+			nameTypes = appendStringUnique(nameTypes, trans.NameTypes...)
+		}
+
+		for _, nameType := range nameTypes {
+			if len(jmnedictOpts.nameTypes) > 0 && !hasString(nameType, jmnedictOpts.nameTypes) || hasString(nameType, jmnedictOpts.excludeNameTypes) {
+				continue
+			}
+
+			typeOpts := jmnedictOpts
+			typeOpts.nameTypes = []string{nameType}
+			nameTerms[nameType] = append(nameTerms[nameType], jmnedictExtractTerms(entry, typeOpts)...)
+		}
+	}
+
+	var nameTypes []string
+	for nameType := range nameTerms {
+		nameTypes = append(nameTypes, nameType)
+	}
+
+	sort.Strings(nameTypes)
+	for _, nameType := range nameTypes {
+		description := nameType
+		if value, ok := entities[nameType]; ok {
+			description = value
+		}
+
+		typePath := siblingPath(outputPath, nameType)
+		log.Printf("writing %s names to '%s'...", nameType, typePath)
+
+		typeTitle := fmt.Sprintf("%s (%s)", title, description)
+		if err := jmnedictWriteDb(typePath, typeTitle, nameTerms[nameType].filter(filter), tags, stride, pretty); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func jmnedictWriteDb(outputPath, title string, terms dbTermList, tags dbTagList, stride int, pretty bool) error {
 	recordData := map[string]dbRecordList{
 		"term": terms.crush(),
-		"tag":  jmnedictBuildTagMeta(entities).crush(),
+		"tag":  tags.crush(),
 	}
 
 	return writeDb(
 		outputPath,
 		title,
 		jmnedictRevision,
+		"",
 		true,
 		recordData,
+		nil,
 		stride,
 		pretty,
 	)
@@ -0,0 +1,249 @@
+/*
+ * Copyright (c) 2016 Alex Yatskov <alex@foosoft.net>
+ * Author: Alex Yatskov <alex@foosoft.net>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of
+ * this software and associated documentation files (the "Software"), to deal in
+ * the Software without restriction, including without limitation the rights to
+ * use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+ * the Software, and to permit persons to whom the Software is furnished to do so,
+ * subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+ * FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+ * COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+ * IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+ * CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/FooSoft/jmdict"
+)
+
+func loadJmnedictFixture(t *testing.T) (jmdict.Jmnedict, map[string]string) {
+	t.Helper()
+
+	reader, err := os.Open(filepath.Join("testdata", "jmnedict", "JMnedict.xml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reader.Close()
+
+	dict, entities, err := jmdict.LoadJmnedictNoTransform(reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return dict, entities
+}
+
+func loadJmnedictTranslationLanguages(t *testing.T, entities map[string]string) map[int][][]string {
+	t.Helper()
+
+	reader, err := os.Open(filepath.Join("testdata", "jmnedict", "JMnedict.xml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reader.Close()
+
+	languages, err := jmnedictLoadTranslationLanguages(reader, entities)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return languages
+}
+
+func findJmnedictEntry(t *testing.T, dict jmdict.Jmnedict, sequence int) jmdict.JmnedictEntry {
+	t.Helper()
+
+	for _, entry := range dict.Entries {
+		if entry.Sequence == sequence {
+			return entry
+		}
+	}
+
+	t.Fatalf("entry %d not found", sequence)
+	return jmdict.JmnedictEntry{}
+}
+
+func TestJmnedictRestrictions(t *testing.T) {
+	dict, _ := loadJmnedictFixture(t)
+	entry := findJmnedictEntry(t, dict, 5000003)
+
+	var headwords []string
+	for _, term := range jmnedictExtractTerms(entry, jmnedictOptions{languages: []string{"eng"}}) {
+		headwords = append(headwords, term.Expression+"/"+term.Reading)
+	}
+
+	expected := []string{"上野/うえの", "上野/かみの", "植野/うえの"}
+	if !reflect.DeepEqual(headwords, expected) {
+		t.Errorf("got headwords %v, expected %v", headwords, expected)
+	}
+}
+
+func TestJmnedictNameTypes(t *testing.T) {
+	dict, _ := loadJmnedictFixture(t)
+	entry := findJmnedictEntry(t, dict, 5000005)
+
+	terms := jmnedictExtractTerms(entry, jmnedictOptions{languages: []string{"eng"}, nameTypes: []string{"surname"}})
+	if len(terms) != 1 || !reflect.DeepEqual(terms[0].Glossary, []interface{}{"Misaki"}) {
+		t.Errorf("unexpected surname terms %+v", terms)
+	}
+
+	terms = jmnedictExtractTerms(entry, jmnedictOptions{languages: []string{"eng"}, excludeNameTypes: []string{"fem"}})
+	if len(terms) != 1 || !reflect.DeepEqual(terms[0].Glossary, []interface{}{"Misaki (cape)"}) {
+		t.Errorf("unexpected terms excluding fem %+v", terms)
+	}
+
+	entry = findJmnedictEntry(t, dict, 5000002)
+	if terms := jmnedictExtractTerms(entry, jmnedictOptions{languages: []string{"eng"}, nameTypes: []string{"surname"}}); len(terms) != 0 {
+		t.Errorf("expected place entry to be dropped, got %+v", terms)
+	}
+
+	if _, err := jmnedictParseNameTypes("surname,planet", map[string]bool{"surname": true}); err == nil {
+		t.Error("expected an unknown name type to fail")
+	}
+}
+
+func TestJmnedictScores(t *testing.T) {
+	dict, entities := loadJmnedictFixture(t)
+
+	scores := make(map[string]int)
+	for _, entry := range dict.Entries {
+		for _, term := range jmnedictExtractTerms(entry, jmnedictOptions{languages: []string{"eng"}}) {
+			scores[term.Expression] = term.Score
+		}
+	}
+
+	expected := map[string]int{"鈴木": 630, "上野": 30, "植野": 30, "東京": 20, "花子": 20, "岬": 30, "ジョン": 20}
+	if !reflect.DeepEqual(scores, expected) {
+		t.Errorf("got scores %v, expected %v", scores, expected)
+	}
+
+	terms := jmnedictExtractTerms(findJmnedictEntry(t, dict, 5000001), jmnedictOptions{languages: []string{"eng"}})
+	if len(terms) != 1 || !reflect.DeepEqual(terms[0].TermTags, []string{"P", "spec"}) {
+		t.Errorf("unexpected priority tags %+v", terms)
+	}
+
+	tags := make(map[string]dbTag)
+	for _, tag := range jmnedictBuildTagMeta(entities) {
+		tags[tag.Name] = tag
+	}
+	for _, name := range []string{"P", "spec", "surname", "station"} {
+		if _, ok := tags[name]; !ok {
+			t.Errorf("tag bank is missing '%s'", name)
+		}
+	}
+	if tags["surname"].Category != "name" {
+		t.Errorf("unexpected surname tag %+v", tags["surname"])
+	}
+}
+
+func TestJmnedictLanguages(t *testing.T) {
+	dict, entities := loadJmnedictFixture(t)
+	entry := findJmnedictEntry(t, dict, 5000002)
+
+	translationLanguages := loadJmnedictTranslationLanguages(t, entities)
+	if expected := map[int][][]string{5000002: {{"eng", "ger"}, {"eng"}}}; !reflect.DeepEqual(translationLanguages, expected) {
+		t.Errorf("got translation languages %v, expected %v", translationLanguages, expected)
+	}
+
+	terms := jmnedictExtractTerms(entry, jmnedictOptions{languages: []string{"ger", "eng"}, labels: true, translationLanguages: translationLanguages})
+	expected := []interface{}{"[ger] Tokio", "[eng] Tokyo", "[eng] Tokyo Station"}
+	if len(terms) != 1 || !reflect.DeepEqual(terms[0].Glossary, expected) {
+		t.Errorf("unexpected terms %+v", terms)
+	}
+
+	terms = jmnedictExtractTerms(entry, jmnedictOptions{languages: []string{"eng"}, translationLanguages: translationLanguages})
+	if expected := []interface{}{"Tokyo", "Tokyo Station"}; len(terms) != 1 || !reflect.DeepEqual(terms[0].Glossary, expected) {
+		t.Errorf("unexpected English terms %+v", terms)
+	}
+
+	if terms := jmnedictExtractTerms(findJmnedictEntry(t, dict, 5000001), jmnedictOptions{languages: []string{"ger"}, translationLanguages: translationLanguages}); len(terms) != 0 {
+		t.Errorf("expected no German terms, got %+v", terms)
+	}
+
+	dir, err := ioutil.TempDir("", "yomichan_test_")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	err = jmnedictExportDb(filepath.Join("testdata", "jmnedict", "JMnedict.xml"), filepath.Join(dir, "unused.zip"), "french", "", defaultStride, false, exportOptions{})
+	if err == nil {
+		t.Error("expected a missing language error")
+	}
+
+	outputPath := filepath.Join(dir, "german.zip")
+	if err := jmnedictExportDb(filepath.Join("testdata", "jmnedict", "JMnedict.xml"), outputPath, "ger", "", defaultStride, false, exportOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	var exported [][]interface{}
+	if err := json.Unmarshal(readZipFile(t, outputPath, "term_bank_1.json"), &exported); err != nil {
+		t.Fatal(err)
+	}
+	if len(exported) != 1 || exported[0][0] != "東京" || !reflect.DeepEqual(exported[0][5], []interface{}{"Tokio"}) {
+		t.Errorf("unexpected German terms %v", exported)
+	}
+}
+
+func TestJmnedictExportSplit(t *testing.T) {
+	dir, err := ioutil.TempDir("", "yomichan_test_")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	options := exportOptions{splitNameTypes: true, excludeNameTypes: "station"}
+	if err := jmnedictExportDb(filepath.Join("testdata", "jmnedict", "JMnedict.xml"), filepath.Join(dir, "names.zip"), "", "", defaultStride, false, options); err != nil {
+		t.Fatal(err)
+	}
+
+	paths, err := filepath.Glob(filepath.Join(dir, "*.zip"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var names []string
+	for _, path := range paths {
+		names = append(names, filepath.Base(path))
+	}
+
+	expected := []string{"names_fem.zip", "names_masc.zip", "names_place.zip", "names_surname.zip"}
+	if !reflect.DeepEqual(names, expected) {
+		t.Fatalf("wrote %v, expected %v", names, expected)
+	}
+
+	var index struct {
+		Title string `json:"title"`
+	}
+	if err := json.Unmarshal(readZipFile(t, filepath.Join(dir, "names_surname.zip"), "index.json"), &index); err != nil {
+		t.Fatal(err)
+	}
+	if index.Title != "JMnedict (family or surname)" {
+		t.Errorf("unexpected title '%s'", index.Title)
+	}
+
+	var terms []json.RawMessage
+	if err := json.Unmarshal(readZipFile(t, filepath.Join(dir, "names_place.zip"), "term_bank_1.json"), &terms); err != nil {
+		t.Fatal(err)
+	}
+	if len(terms) != 2 {
+		t.Errorf("expected 2 place terms, got %d", len(terms))
+	}
+}
@@ -24,7 +24,10 @@ package main
 
 import (
 	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"log"
@@ -33,6 +36,7 @@ import (
 	"path/filepath"
 	"regexp"
 	"runtime"
+	"sort"
 	"strconv"
 	"strings"
 )
@@ -42,10 +46,16 @@ type epwingEntry struct {
 	Text    string `json:"text"`
 }
 
+type epwingMedia struct {
+	Type string `json:"type"`
+	Data []byte `json:"data"`
+}
+
 type epwingSubbook struct {
 	Title     string        `json:"title"`
 	Copyright string        `json:"copyright"`
 	Entries   []epwingEntry `json:"entries"`
+	Media     []epwingMedia `json:"media"`
 }
 
 type epwingBook struct {
@@ -62,86 +72,435 @@ type epwingExtractor interface {
 	getRevision() string
 }
 
-func epwingExportDb(inputPath, outputPath, language, title string, stride int, pretty bool) error {
-	stat, err := os.Stat(inputPath)
-	if err != nil {
-		return err
+var epwingPartsOfSpeech = map[string]string{
+	"名":     "名詞",
+	"代":     "代名詞",
+	"形":     "形容詞",
+	"形ク":    "ク活用形容詞",
+	"形シク":   "シク活用形容詞",
+	"形動":    "形容動詞",
+	"形動ナリ":  "ナリ活用形容動詞",
+	"形動タリ":  "タリ活用形容動詞",
+	"形動トタル": "トタル活用形容動詞",
+	"副":     "副詞",
+	"副ト":    "「と」を伴う副詞",
+	"副トニ":   "「と」「に」を伴う副詞",
+	"トニ":    "「と」「に」を伴う副詞",
+	"連体":    "連体詞",
+	"接":     "接続詞",
+	"感":     "感動詞",
+	"助動":    "助動詞",
+	"格助":    "格助詞",
+	"係助":    "係助詞",
+	"副助":    "副助詞",
+	"終助":    "終助詞",
+	"接助":    "接続助詞",
+	"間助":    "間投助詞",
+	"並助":    "並立助詞",
+	"接尾":    "接尾語",
+	"接頭":    "接頭語",
+	"補形":    "補助形容詞",
+	"枕":     "枕詞",
+	"連語":    "連語",
+	"造語":    "造語成分",
+}
+
+var (
+	epwingVerbExp       = regexp.MustCompile(`^(自他|自|他)?(補動|動)?([アカガサザタダナハバマヤラワ])?(五|四|上一|下一|上二|下二|サ変|カ変|ナ変|ラ変)(?:[［（(][^］）)]*[］）)])?$`)
+	epwingVerbConjNotes = map[string]string{
+		"五":  "五段活用",
+		"四":  "四段活用",
+		"上一": "上一段活用",
+		"下一": "下一段活用",
+		"上二": "上二段活用",
+		"下二": "下二段活用",
+		"サ変": "サ行変格活用",
+		"カ変": "カ行変格活用",
+		"ナ変": "ナ行変格活用",
+		"ラ変": "ラ行変格活用",
 	}
+)
 
-	var toolExec bool
-	if stat.IsDir() {
-		toolExec = true
-	} else if filepath.Base(inputPath) == "CATALOGS" {
-		inputPath = filepath.Dir(inputPath)
-		toolExec = true
+func epwingPartOfSpeechTag(label string) (dbTag, bool) {
+	tag := dbTag{Category: "partOfSpeech", Order: -3}
+
+	if notes, ok := epwingPartsOfSpeech[label]; ok {
+		tag.Name = label
+		tag.Notes = notes
+		return tag, true
 	}
 
-	var data []byte
-	if toolExec {
-		exePath, err := os.Executable()
-		if err != nil {
-			return err
+	matches := epwingVerbExp.FindStringSubmatch(label)
+	if matches == nil || len(matches[1]) == 0 && len(matches[2]) == 0 {
+		return tag, false
+	}
+
+	var kind string
+	switch {
+	case matches[2] == "補動":
+		kind = "補助動詞"
+	case matches[1] == "自他":
+		kind = "自他動詞"
+	case matches[1] == "自":
+		kind = "自動詞"
+	case matches[1] == "他":
+		kind = "他動詞"
+	default:
+		kind = "動詞"
+	}
+
+	var row string
+	if len(matches[3]) > 0 {
+		row = matches[3] + "行"
+	}
+
+	tag.Name = matches[1] + matches[2] + matches[3] + matches[4]
+	tag.Notes = row + epwingVerbConjNotes[matches[4]] + "の" + kind
+	return tag, true
+}
+
+func epwingExportPartsOfSpeech(term *dbTerm, labels []string) {
+	for _, label := range labels {
+		if tag, ok := epwingPartOfSpeechTag(label); ok {
+			term.addDefinitionTags(tag.Name)
 		}
+	}
+}
 
-		toolPath := filepath.Join("bin", runtime.GOOS, "zero-epwing")
-		if runtime.GOOS == "windows" {
-			toolPath += ".exe"
+func epwingBuildTagMeta(terms dbTermList) dbTagList {
+	var (
+		tags  dbTagList
+		names []string
+	)
+
+	for _, term := range terms {
+		for _, name := range term.DefinitionTags {
+			if hasString(name, names) {
+				continue
+			}
+
+			if tag, ok := epwingPartOfSpeechTag(name); ok {
+				tags = append(tags, tag)
+				names = append(names, name)
+			}
 		}
+	}
 
-		toolPath = filepath.Join(filepath.Dir(exePath), toolPath)
+	return tags
+}
 
-		if _, err = os.Stat(toolPath); err != nil {
-			return fmt.Errorf("failed to find zero-epwing in '%s'", toolPath)
+// Audio is out of scope, as glossaries have no way to reference it, so only
+// image types are exported and anything else is logged and skipped.
+var epwingMediaExtensions = map[string]string{
+	"bmp":  "bmp",
+	"gif":  "gif",
+	"jpeg": "jpg",
+	"png":  "png",
+}
+
+func epwingExportMedia(subbook epwingSubbook, index int, files map[string][]byte) []string {
+	markers := make([]string, len(subbook.Media))
+	for i, media := range subbook.Media {
+		ext, ok := epwingMediaExtensions[media.Type]
+		if !ok {
+			log.Printf("skipping unsupported media type '%s' in '%s'\n", media.Type, subbook.Title)
+			continue
 		}
 
-		cmd := exec.Command(toolPath, "--entries", inputPath)
+		path := fmt.Sprintf("media/%d/%d.%s", index, i, ext)
+		files[path] = media.Data
+		markers[i] = "{{" + path + "}}"
+	}
 
-		stdout, err := cmd.StdoutPipe()
-		if err != nil {
-			return err
+	return markers
+}
+
+var epwingMediaPathExp = regexp.MustCompile(`{{(media/[^{}]+)}}`)
+
+var epwingReferenceExp = regexp.MustCompile(`[→⇒]\s*(?:「([^」]+)」|([^\s。、，,．；：（）()［］【】〔〕〈〉「」{}→⇒・①-⑳]+)(?:【([^】]+)】)?)|「([^」]+)」(?:を)?参照`)
+
+func epwingReferenceTarget(matches []string) string {
+	for _, index := range []int{1, 3, 2, 4} {
+		if target := strings.TrimSpace(matches[index]); len(target) > 0 {
+			return target
 		}
+	}
 
-		stderr, err := cmd.StderrPipe()
-		if err != nil {
-			return err
+	return ""
+}
+
+func epwingBuildTextContent(text string) []interface{} {
+	var content []interface{}
+	for index, line := range strings.Split(text, "\n") {
+		if index > 0 {
+			content = append(content, dbContentNode{Tag: "br"})
 		}
 
-		log.Printf("invoking zero-epwing from '%s'...\n", toolPath)
-		if err := cmd.Start(); err != nil {
-			return err
+		var offset int
+		for _, indices := range epwingMediaPathExp.FindAllStringSubmatchIndex(line, -1) {
+			if indices[0] > offset {
+				content = append(content, line[offset:indices[0]])
+			}
+
+			content = append(content, dbContentNode{Tag: "img", Path: line[indices[2]:indices[3]]})
+			offset = indices[1]
 		}
 
-		go func() {
-			scanner := bufio.NewScanner(stderr)
-			for scanner.Scan() {
-				log.Printf("\t > %s\n", scanner.Text())
+		if len(line) > offset {
+			content = append(content, line[offset:])
+		}
+	}
+
+	return content
+}
+
+func epwingBuildContent(text string) (interface{}, []string) {
+	var (
+		content []interface{}
+		targets []string
+		offset  int
+	)
+
+	for _, indices := range epwingReferenceExp.FindAllStringSubmatchIndex(text, -1) {
+		matches := make([]string, len(indices)/2)
+		for i := range matches {
+			if indices[i*2] >= 0 {
+				matches[i] = text[indices[i*2]:indices[i*2+1]]
 			}
-		}()
+		}
 
-		if data, err = ioutil.ReadAll(stdout); err != nil {
-			return err
+		target := epwingReferenceTarget(matches)
+		if len(target) == 0 {
+			continue
 		}
 
-		if err := cmd.Wait(); err != nil {
+		link := makeQueryLink(target, matches[0])
+
+		content = append(content, epwingBuildTextContent(text[offset:indices[0]])...)
+		content = append(content, link)
+		targets = append(targets, target)
+		offset = indices[1]
+	}
+
+	if len(targets) == 0 && !epwingMediaPathExp.MatchString(text) {
+		return nil, nil
+	}
+
+	content = append(content, epwingBuildTextContent(text[offset:])...)
+	return makeStructuredContent(content), targets
+}
+
+func epwingExportContent(terms dbTermList, reportPath string) error {
+	headwords := make(map[string]bool)
+	for _, term := range terms {
+		headwords[term.Expression] = true
+		headwords[term.Reading] = true
+	}
+
+	var unresolved []string
+	reported := make(map[string]bool)
+
+	for i := range terms {
+		term := &terms[i]
+		for j, glossary := range term.Glossary {
+			text, ok := glossary.(string)
+			if !ok {
+				continue
+			}
+
+			content, targets := epwingBuildContent(text)
+			if content == nil {
+				continue
+			}
+
+			term.Glossary[j] = content
+			for _, target := range targets {
+				if headwords[target] {
+					continue
+				}
+
+				line := fmt.Sprintf("%s\t%s\t%s", term.Expression, term.Reading, target)
+				if !reported[line] {
+					reported[line] = true
+					unresolved = append(unresolved, line)
+				}
+			}
+		}
+	}
+
+	if len(unresolved) == 0 {
+		return nil
+	}
+
+	log.Printf("found %d unresolved cross-references\n", len(unresolved))
+	if len(reportPath) == 0 {
+		return nil
+	}
+
+	sort.Strings(unresolved)
+	report := "expression\treading\ttarget\n" + strings.Join(unresolved, "\n") + "\n"
+	return ioutil.WriteFile(reportPath, []byte(report), 0644)
+}
+
+func epwingCacheKey(inputPath string, options exportOptions) (string, error) {
+	absPath, err := filepath.Abs(filepath.Join(inputPath, "CATALOGS"))
+	if err != nil {
+		return "", err
+	}
+
+	hash := sha1.New()
+	fmt.Fprintf(hash, "%s\n%s\n%t\n", absPath, options.epwingReader, options.media)
+
+	err = filepath.Walk(inputPath, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
 			return err
 		}
 
-		log.Println("completed zero-epwing processing")
+		if !info.IsDir() {
+			fmt.Fprintf(hash, "%s\t%d\t%d\n", path, info.Size(), info.ModTime().UnixNano())
+		}
+
+		return nil
+	})
+
+	return hex.EncodeToString(hash.Sum(nil)), err
+}
+
+func epwingReadBook(inputPath string, options exportOptions) (epwingBook, error) {
+	if options.noCache {
+		return epwingDumpBook(inputPath, options)
+	}
+
+	var cachePath string
+	if cacheDir, err := makeCacheDir(); err != nil {
+		log.Printf("unable to create cache directory: %s\n", err.Error())
+	} else if key, err := epwingCacheKey(inputPath, options); err != nil {
+		log.Printf("unable to compute cache key: %s\n", err.Error())
+	} else {
+		cachePath = filepath.Join(cacheDir, key+".json")
+	}
+
+	if len(cachePath) > 0 && !options.refreshCache {
+		if data, err := ioutil.ReadFile(cachePath); err == nil {
+			var book epwingBook
+			if err := json.Unmarshal(data, &book); err == nil {
+				log.Printf("using cached dump from '%s'\n", cachePath)
+				return book, nil
+			}
+		}
+	}
+
+	book, err := epwingDumpBook(inputPath, options)
+	if err != nil || len(cachePath) == 0 {
+		return book, err
+	}
+
+	if data, err := json.Marshal(book); err != nil {
+		log.Printf("unable to encode cached dump: %s\n", err.Error())
+	} else if err := ioutil.WriteFile(cachePath, data, 0644); err != nil {
+		log.Printf("unable to write cached dump: %s\n", err.Error())
 	} else {
-		data, err = ioutil.ReadFile(inputPath)
+		log.Printf("cached dump in '%s'\n", cachePath)
 	}
 
-	if err != nil {
-		return err
+	return book, nil
+}
+
+// Media is only extracted by the native reader, as zero-epwing has no way to
+// dump it, so a fallback to zero-epwing produces a book without media.
+func epwingDumpBook(inputPath string, options exportOptions) (epwingBook, error) {
+	switch options.epwingReader {
+	case "native":
+		book, err := jisx4081ReadBook(inputPath, options.media)
+		if err == nil {
+			return book, nil
+		}
+
+		log.Printf("native reader failed (%s), falling back to zero-epwing\n", err.Error())
+		if options.media {
+			log.Println("media will not be extracted by zero-epwing")
+		}
+	case "", "zero-epwing":
+		if options.media {
+			return epwingBook{}, errors.New("media extraction requires the native EPWING reader")
+		}
+	default:
+		return epwingBook{}, fmt.Errorf("unrecognized EPWING reader '%s'", options.epwingReader)
 	}
 
 	var book epwingBook
-	if err := json.Unmarshal(data, &book); err != nil {
-		return err
+	data, err := epwingInvokeTool(inputPath, options)
+	if err != nil {
+		return book, err
 	}
 
-	translateExp := regexp.MustCompile(`{{([nw])_(\d+)}}`)
-	epwingExtractors := map[string]epwingExtractor{
+	err = json.Unmarshal(data, &book)
+	return book, err
+}
+
+func epwingInvokeTool(inputPath string, options exportOptions) ([]byte, error) {
+	toolPath := options.toolPath
+	if len(toolPath) == 0 {
+		exePath, err := os.Executable()
+		if err != nil {
+			return nil, err
+		}
+
+		toolPath = filepath.Join("bin", runtime.GOOS, "zero-epwing")
+		if runtime.GOOS == "windows" {
+			toolPath += ".exe"
+		}
+
+		toolPath = filepath.Join(filepath.Dir(exePath), toolPath)
+	}
+
+	if _, err := os.Stat(toolPath); err != nil {
+		return nil, fmt.Errorf("failed to find zero-epwing in '%s'", toolPath)
+	}
+
+	cmd := exec.Command(toolPath, "--entries", inputPath)
+
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		return nil, err
+	}
+
+	log.Printf("invoking zero-epwing from '%s'...\n", toolPath)
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+
+	stderrDone := make(chan struct{})
+	go func() {
+		scanner := bufio.NewScanner(stderr)
+		for scanner.Scan() {
+			log.Printf("\t > %s\n", scanner.Text())
+		}
+		close(stderrDone)
+	}()
+
+	data, err := ioutil.ReadAll(stdout)
+	<-stderrDone
+
+	if errWait := cmd.Wait(); errWait != nil {
+		return nil, fmt.Errorf("zero-epwing failed: %s", errWait.Error())
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	log.Println("completed zero-epwing processing")
+	return data, nil
+}
+
+func makeEpwingExtractors() map[string]epwingExtractor {
+	return map[string]epwingExtractor{
 		"三省堂　スーパー大辞林":    makeDaijirinExtractor(),
 		"大辞泉":            makeDaijisenExtractor(),
 		"明鏡国語辞典":         makeMeikyouExtractor(),
@@ -154,76 +513,143 @@ func epwingExportDb(inputPath, outputPath, language, title string, stride int, p
 		"故事ことわざ辞典":       makeGakkenExtractor(),
 		"学研漢和大字典":        makeGakkenExtractor(),
 	}
+}
+
+func epwingExtractBook(book epwingBook, media map[string][]byte) (dbTermList, dbKanjiList, []string, error) {
+	translateExp := regexp.MustCompile(`{{([nwm])_(\d+)}}`)
+	epwingExtractors := makeEpwingExtractors()
 
 	var (
 		terms     dbTermList
 		kanji     dbKanjiList
 		revisions []string
-		titles    []string
+		sequence  int
 	)
 
-	log.Println("formatting dictionary data...")
+	for index, subbook := range book.Subbooks {
+		extractor, ok := epwingExtractors[subbook.Title]
+		if !ok {
+			return nil, nil, nil, fmt.Errorf("failed to find compatible extractor for '%s'", subbook.Title)
+		}
 
-	var sequence int
+		fontNarrow := extractor.getFontNarrow()
+		fontWide := extractor.getFontWide()
+		mediaMarkers := epwingExportMedia(subbook, index, media)
 
-	for _, subbook := range book.Subbooks {
-		if extractor, ok := epwingExtractors[subbook.Title]; ok {
-			fontNarrow := extractor.getFontNarrow()
-			fontWide := extractor.getFontWide()
+		translate := func(str string) string {
+			for _, matches := range translateExp.FindAllStringSubmatch(str, -1) {
+				code, _ := strconv.Atoi(matches[2])
 
-			translate := func(str string) string {
-				for _, matches := range translateExp.FindAllStringSubmatch(str, -1) {
-					var font map[int]string
+				var replacement string
+				if matches[1] == "m" {
+					if code < len(mediaMarkers) {
+						replacement = mediaMarkers[code]
+					}
+				} else {
+					font := fontWide
 					if matches[1] == "n" {
 						font = fontNarrow
-					} else {
-						font = fontWide
 					}
 
-					code, _ := strconv.Atoi(matches[2])
-					replacement, ok := font[code]
-					if !ok {
+					var found bool
+					if replacement, found = font[code]; !found {
 						replacement = "�"
 					}
-
-					str = strings.Replace(str, matches[0], replacement, -1)
 				}
 
-				return str
+				str = strings.Replace(str, matches[0], replacement, -1)
 			}
 
-			for _, entry := range subbook.Entries {
-				entry.Heading = translate(entry.Heading)
-				entry.Text = translate(entry.Text)
+			return str
+		}
 
-				terms = append(terms, extractor.extractTerms(entry, sequence)...)
-				kanji = append(kanji, extractor.extractKanji(entry)...)
+		for _, entry := range subbook.Entries {
+			entry.Heading = translate(entry.Heading)
+			entry.Text = translate(entry.Text)
 
-				sequence++
-			}
+			terms = append(terms, extractor.extractTerms(entry, sequence)...)
+			kanji = append(kanji, extractor.extractKanji(entry)...)
 
-			revisions = append(revisions, extractor.getRevision())
-			titles = append(titles, subbook.Title)
-		} else {
-			return fmt.Errorf("failed to find compatible extractor for '%s'", subbook.Title)
+			sequence++
 		}
+
+		revisions = append(revisions, extractor.getRevision())
+	}
+
+	return terms, kanji, revisions, nil
+}
+
+func epwingExportDb(inputPath, outputPath, language, title string, stride int, pretty bool, options exportOptions) error {
+	stat, err := os.Stat(inputPath)
+	if err != nil {
+		return err
+	}
+
+	var toolExec bool
+	if stat.IsDir() {
+		toolExec = true
+	} else if filepath.Base(inputPath) == "CATALOGS" {
+		inputPath = filepath.Dir(inputPath)
+		toolExec = true
+	}
+
+	var book epwingBook
+	if toolExec {
+		if book, err = epwingReadBook(inputPath, options); err != nil {
+			return err
+		}
+	} else {
+		data, err := ioutil.ReadFile(inputPath)
+		if err != nil {
+			return err
+		}
+
+		if err := json.Unmarshal(data, &book); err != nil {
+			return err
+		}
+	}
+
+	log.Println("formatting dictionary data...")
+
+	media := make(map[string][]byte)
+	terms, kanji, revisions, err := epwingExtractBook(book, media)
+	if err != nil {
+		return err
+	}
+
+	var titles []string
+	for _, subbook := range book.Subbooks {
+		titles = append(titles, subbook.Title)
 	}
 
 	if title == "" {
 		title = strings.Join(titles, ", ")
 	}
 
+	if len(media) > 0 {
+		log.Printf("extracted %d media files\n", len(media))
+	}
+
+	terms = terms.filter(makeTermFilter(options))
+
+	if err := epwingExportContent(terms, options.referenceReport); err != nil {
+		return err
+	}
+
 	recordData := map[string]dbRecordList{
 		"kanji": kanji.crush(),
 		"term":  terms.crush(),
+		"tag":   epwingBuildTagMeta(terms).crush(),
 	}
 
 	return writeDb(
 		outputPath,
 		title,
 		strings.Join(revisions, ";"),
+		"",
 		true,
 		recordData,
+		media,
 		stride,
 		pretty,
 	)
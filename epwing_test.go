@@ -0,0 +1,367 @@
+/*
+ * Copyright (c) 2016 Alex Yatskov <alex@foosoft.net>
+ * Author: Alex Yatskov <alex@foosoft.net>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of
+ * this software and associated documentation files (the "Software"), to deal in
+ * the Software without restriction, including without limitation the rights to
+ * use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+ * the Software, and to permit persons to whom the Software is furnished to do so,
+ * subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+ * FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+ * COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+ * IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+ * CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"flag"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+var update = flag.Bool("update", false, "update golden files")
+
+func compareGolden(t *testing.T, goldenPath string, value interface{}) {
+	t.Helper()
+
+	actual, err := json.MarshalIndent(value, "", "    ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	actual = append(actual, '\n')
+
+	if *update {
+		if err := ioutil.WriteFile(goldenPath, actual, 0644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+
+	expected, err := ioutil.ReadFile(goldenPath)
+	if err != nil {
+		t.Fatalf("%s (run with -update to create it)", err.Error())
+	}
+
+	if !bytes.Equal(actual, expected) {
+		t.Errorf("output does not match '%s' (run with -update to accept):\n%s", goldenPath, actual)
+	}
+}
+
+func TestEpwingExtractors(t *testing.T) {
+	fixturePaths, err := filepath.Glob(filepath.Join("testdata", "epwing", "*.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	covered := make(map[string]bool)
+	for _, fixturePath := range fixturePaths {
+		name := strings.TrimSuffix(filepath.Base(fixturePath), ".json")
+
+		t.Run(name, func(t *testing.T) {
+			data, err := ioutil.ReadFile(fixturePath)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var book epwingBook
+			if err := json.Unmarshal(data, &book); err != nil {
+				t.Fatal(err)
+			}
+
+			for _, subbook := range book.Subbooks {
+				covered[subbook.Title] = true
+			}
+
+			terms, _, _, err := epwingExtractBook(book, make(map[string][]byte))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if err := epwingExportContent(terms, ""); err != nil {
+				t.Fatal(err)
+			}
+
+			compareGolden(t, filepath.Join("testdata", "epwing", name+".golden"), terms)
+		})
+	}
+
+	for title := range makeEpwingExtractors() {
+		if !covered[title] {
+			t.Errorf("no fixture covers the extractor registered for '%s'", title)
+		}
+	}
+}
+
+func buildFakeTool(t *testing.T, dir string) string {
+	t.Helper()
+
+	toolPath := filepath.Join(dir, "zero-epwing")
+	cmd := exec.Command("go", "build", "-o", toolPath, "./testdata/zero-epwing")
+	if output, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("failed to build fake zero-epwing: %s\n%s", err.Error(), output)
+	}
+
+	return toolPath
+}
+
+func readZipFile(t *testing.T, archivePath, name string) []byte {
+	t.Helper()
+
+	archive, err := zip.OpenReader(archivePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer archive.Close()
+
+	for _, file := range archive.File {
+		if file.Name != name {
+			continue
+		}
+
+		reader, err := file.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer reader.Close()
+
+		data, err := ioutil.ReadAll(reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return data
+	}
+
+	t.Fatalf("'%s' not found in '%s'", name, archivePath)
+	return nil
+}
+
+func TestEpwingExportTool(t *testing.T) {
+	dir, err := ioutil.TempDir("", "yomichan_test_")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	var (
+		options     = exportOptions{toolPath: buildFakeTool(t, dir), noCache: true}
+		discPath    = filepath.Join(dir, "disc")
+		catalogPath = filepath.Join(discPath, "CATALOGS")
+		outputPath  = filepath.Join(dir, "output.zip")
+	)
+
+	if err := os.Mkdir(discPath, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(catalogPath, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	fixturePath, err := filepath.Abs(filepath.Join("testdata", "epwing", "daijirin.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	os.Setenv("FAKE_EPWING_OUTPUT", fixturePath)
+	os.Setenv("FAKE_EPWING_STDERR", "reading subbook 1 of 1\nwarning: unknown gaiji")
+	defer os.Unsetenv("FAKE_EPWING_OUTPUT")
+	defer os.Unsetenv("FAKE_EPWING_STDERR")
+
+	var logs bytes.Buffer
+	log.SetOutput(&logs)
+	defer log.SetOutput(os.Stderr)
+
+	t.Run("success", func(t *testing.T) {
+		logs.Reset()
+		if err := epwingExportDb(catalogPath, outputPath, "", "", defaultStride, false, options); err != nil {
+			t.Fatal(err)
+		}
+
+		for _, line := range []string{"> args: --entries " + discPath, "> reading subbook 1 of 1", "> warning: unknown gaiji"} {
+			if !strings.Contains(logs.String(), line) {
+				t.Errorf("log output is missing '%s':\n%s", line, logs.String())
+			}
+		}
+
+		var index struct {
+			Title    string `json:"title"`
+			Revision string `json:"revision"`
+		}
+		if err := json.Unmarshal(readZipFile(t, outputPath, "index.json"), &index); err != nil {
+			t.Fatal(err)
+		}
+		if index.Title != "三省堂　スーパー大辞林" || index.Revision != "daijirin1" {
+			t.Errorf("unexpected index %+v", index)
+		}
+
+		var terms []json.RawMessage
+		if err := json.Unmarshal(readZipFile(t, outputPath, "term_bank_1.json"), &terms); err != nil {
+			t.Fatal(err)
+		}
+		if len(terms) != 4 {
+			t.Errorf("expected 4 terms, got %d", len(terms))
+		}
+	})
+
+	t.Run("media", func(t *testing.T) {
+		mediaOptions := options
+		mediaOptions.media = true
+
+		logs.Reset()
+		err := epwingExportDb(discPath, filepath.Join(dir, "media.zip"), "", "", defaultStride, false, mediaOptions)
+		if err == nil || !strings.Contains(err.Error(), "native") {
+			t.Errorf("expected media to require the native reader, got %v", err)
+		}
+		if strings.Contains(logs.String(), "> args:") {
+			t.Errorf("zero-epwing should not run when media is requested:\n%s", logs.String())
+		}
+
+		mediaOptions.epwingReader = "native"
+
+		logs.Reset()
+		if err := epwingExportDb(discPath, filepath.Join(dir, "media.zip"), "", "", defaultStride, false, mediaOptions); err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(logs.String(), "> args: --entries "+discPath+"\n") {
+			t.Errorf("zero-epwing fallback was not invoked with plain arguments:\n%s", logs.String())
+		}
+	})
+
+	t.Run("exit code", func(t *testing.T) {
+		os.Setenv("FAKE_EPWING_EXIT", "3")
+		defer os.Unsetenv("FAKE_EPWING_EXIT")
+
+		failedPath := filepath.Join(dir, "failed.zip")
+		err := epwingExportDb(discPath, failedPath, "", "", defaultStride, false, options)
+		if err == nil || !strings.Contains(err.Error(), "exit status 3") {
+			t.Fatalf("expected exit status error, got %v", err)
+		}
+		if _, err := os.Stat(failedPath); !os.IsNotExist(err) {
+			t.Errorf("output archive should not exist after a failed run")
+		}
+	})
+
+	t.Run("malformed output", func(t *testing.T) {
+		os.Setenv("FAKE_EPWING_OUTPUT", catalogPath)
+		defer os.Setenv("FAKE_EPWING_OUTPUT", fixturePath)
+
+		if err := epwingExportDb(discPath, filepath.Join(dir, "malformed.zip"), "", "", defaultStride, false, options); err == nil {
+			t.Fatal("expected a JSON parse error")
+		}
+	})
+}
+
+func TestEpwingExportCache(t *testing.T) {
+	dir, err := ioutil.TempDir("", "yomichan_test_")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	var (
+		options     = exportOptions{toolPath: buildFakeTool(t, dir)}
+		discPath    = filepath.Join(dir, "disc")
+		catalogPath = filepath.Join(discPath, "CATALOGS")
+		outputPath  = filepath.Join(dir, "output.zip")
+	)
+
+	if err := os.Mkdir(discPath, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(catalogPath, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	fixturePath, err := filepath.Abs(filepath.Join("testdata", "epwing", "daijirin.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	os.Setenv("FAKE_EPWING_OUTPUT", fixturePath)
+	defer os.Unsetenv("FAKE_EPWING_OUTPUT")
+
+	for _, name := range []string{"XDG_CACHE_HOME", "HOME"} {
+		defer os.Setenv(name, os.Getenv(name))
+		os.Setenv(name, filepath.Join(dir, "cache"))
+	}
+
+	var logs bytes.Buffer
+	log.SetOutput(&logs)
+	defer log.SetOutput(os.Stderr)
+
+	export := func(options exportOptions) int {
+		t.Helper()
+
+		logs.Reset()
+		if err := epwingExportDb(discPath, outputPath, "", "", defaultStride, false, options); err != nil {
+			t.Fatal(err)
+		}
+
+		return strings.Count(logs.String(), "> args:")
+	}
+
+	refreshOptions := options
+	refreshOptions.refreshCache = true
+
+	noCacheOptions := options
+	noCacheOptions.noCache = true
+
+	steps := []struct {
+		name    string
+		options exportOptions
+		prepare func() error
+		runs    int
+	}{
+		{"first run", options, nil, 1},
+		{"cache hit", options, nil, 0},
+		{"refresh cache", refreshOptions, nil, 1},
+		{"no cache", noCacheOptions, nil, 1},
+		{"cache kept", options, nil, 0},
+		{"mtime change", options, func() error {
+			mtime := time.Now().Add(time.Hour)
+			return os.Chtimes(catalogPath, mtime, mtime)
+		}, 1},
+		{"cache hit after mtime change", options, nil, 0},
+		{"size change", options, func() error {
+			info, err := os.Stat(catalogPath)
+			if err != nil {
+				return err
+			}
+			if err := ioutil.WriteFile(catalogPath, []byte{0}, 0644); err != nil {
+				return err
+			}
+			return os.Chtimes(catalogPath, info.ModTime(), info.ModTime())
+		}, 1},
+		{"cache hit after size change", options, nil, 0},
+	}
+
+	for _, step := range steps {
+		if step.prepare != nil {
+			if err := step.prepare(); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		if runs := export(step.options); runs != step.runs {
+			t.Errorf("%s: expected zero-epwing to run %d time(s), ran %d:\n%s", step.name, step.runs, runs, logs.String())
+		}
+	}
+}
@@ -24,23 +24,22 @@ package main
 
 import (
 	"bufio"
-	"os"
 	"strconv"
 	"strings"
 )
 
 const frequencyRevision = "frequency1"
 
-func frequencyTermsExportDb(inputPath, outputPath, language, title string, stride int, pretty bool) error {
+func frequencyTermsExportDb(inputPath, outputPath, language, title string, stride int, pretty bool, options exportOptions) error {
 	return frequncyExportDb(inputPath, outputPath, language, title, stride, pretty, "term_meta")
 }
 
-func frequencyKanjiExportDb(inputPath, outputPath, language, title string, stride int, pretty bool) error {
+func frequencyKanjiExportDb(inputPath, outputPath, language, title string, stride int, pretty bool, options exportOptions) error {
 	return frequncyExportDb(inputPath, outputPath, language, title, stride, pretty, "kanji_meta")
 }
 
 func frequncyExportDb(inputPath, outputPath, language, title string, stride int, pretty bool, key string) error {
-	reader, err := os.Open(inputPath)
+	reader, err := openInput(inputPath)
 	if err != nil {
 		return err
 	}
@@ -83,8 +82,10 @@ func frequncyExportDb(inputPath, outputPath, language, title string, stride int,
 		outputPath,
 		title,
 		frequencyRevision,
+		"",
 		false,
 		recordData,
+		nil,
 		stride,
 		pretty,
 	)
@@ -110,11 +110,12 @@ func (e *gakkenExtractor) extractTerms(entry epwingEntry, sequence int) []dbTerm
 		for _, reading := range readings {
 			term := dbTerm{
 				Expression: reading,
-				Glossary:   []string{entryText},
+				Glossary:   []interface{}{entryText},
 				Sequence:   sequence,
 			}
 
 			e.exportRules(&term, tags)
+			epwingExportPartsOfSpeech(&term, tags)
 			terms = append(terms, term)
 		}
 
@@ -127,11 +128,12 @@ func (e *gakkenExtractor) extractTerms(entry epwingEntry, sequence int) []dbTerm
 				term := dbTerm{
 					Expression: expression,
 					Reading:    reading,
-					Glossary:   []string{entryText},
+					Glossary:   []interface{}{entryText},
 					Sequence:   sequence,
 				}
 
 				e.exportRules(&term, tags)
+				epwingExportPartsOfSpeech(&term, tags)
 				terms = append(terms, term)
 			}
 		}
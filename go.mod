@@ -6,4 +6,6 @@ require (
 	github.com/FooSoft/jmdict v0.0.0-20190926045629-808d66c7b050
 	github.com/andlabs/ui v0.0.0-20180902183112-867a9e5a498d
 	github.com/mattn/go-sqlite3 v2.0.2+incompatible
+	github.com/ulikunitz/xz v0.5.12
+	golang.org/x/text v0.3.7
 )
@@ -0,0 +1,578 @@
+/*
+ * Copyright (c) 2016 Alex Yatskov <alex@foosoft.net>
+ * Author: Alex Yatskov <alex@foosoft.net>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of
+ * this software and associated documentation files (the "Software"), to deal in
+ * the Software without restriction, including without limitation the rights to
+ * use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+ * the Software, and to permit persons to whom the Software is furnished to do so,
+ * subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+ * FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+ * COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+ * IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+ * CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"golang.org/x/text/encoding/japanese"
+)
+
+const (
+	jisx4081PageSize        = 2048
+	jisx4081CatalogSize     = 164
+	jisx4081TitleSize       = 80
+	jisx4081DirectorySize   = 8
+	jisx4081MaxHeadingSize  = 1024
+	jisx4081MaxTextSize     = 65536
+	jisx4081IndexCopyright  = 0x02
+	jisx4081IndexWordKana   = 0x90
+	jisx4081IndexWordAsis   = 0x91
+	jisx4081IndexWordLatin  = 0x92
+	jisx4081PageLeaf        = 0x80
+	jisx4081PageGroupEntry  = 0x10
+	jisx4081GroupSingle     = 0x00
+	jisx4081GroupStart      = 0x80
+	jisx4081GroupElement    = 0xc0
+	jisx4081EscapeCharacter = 0x1f
+)
+
+var jisx4081EscapeSizes = map[byte]int{
+	0x09: 4, 0x1a: 4, 0x1b: 4, 0x1c: 4, 0x1d: 4, 0x1e: 4,
+	0x39: 46, 0x3c: 20, 0x41: 4, 0x44: 12, 0x45: 4, 0x4a: 18,
+	0x4b: 8, 0x4c: 4, 0x4d: 20, 0x4f: 34, 0x52: 8, 0x53: 10,
+	0x62: 8, 0x63: 8, 0x64: 8, 0xe0: 4,
+}
+
+type jisx4081Subbook struct {
+	title     string
+	directory string
+	indexPage int
+	text      []byte
+	media     bool
+	mediaIds  map[string]int
+	book      *epwingSubbook
+}
+
+type jisx4081Index struct {
+	id        byte
+	startPage int
+	pageCount int
+}
+
+func jisx4081Position(page, offset int) int {
+	return (page-1)*jisx4081PageSize + offset
+}
+
+func (s *jisx4081Subbook) checkPosition(position int) error {
+	if position < 0 || position >= len(s.text) {
+		return fmt.Errorf("position %d is out of range in subbook '%s'", position, s.title)
+	}
+
+	return nil
+}
+
+func jisx4081DecodeBCD(data []byte) int {
+	var value int
+	for _, b := range data {
+		value = value*100 + int(b>>4)*10 + int(b&0x0f)
+	}
+
+	return value
+}
+
+var (
+	jisx4081Characters     [94 * 94]string
+	jisx4081CharactersOnce sync.Once
+)
+
+func jisx4081DecodeJis(data []byte) string {
+	jisx4081CharactersOnce.Do(func() {
+		decoder := japanese.EUCJP.NewDecoder()
+		for i := range jisx4081Characters {
+			decoded, err := decoder.Bytes([]byte{byte(i/94 + 0xa1), byte(i%94 + 0xa1)})
+			if err != nil {
+				decoded = []byte("�")
+			}
+
+			jisx4081Characters[i] = string(decoded)
+		}
+	})
+
+	var builder strings.Builder
+	for i := 0; i+1 < len(data); i += 2 {
+		c1, c2 := data[i], data[i+1]
+		if c1 < 0x21 || c1 > 0x7e || c2 < 0x21 || c2 > 0x7e {
+			continue
+		}
+
+		builder.WriteString(jisx4081Characters[int(c1-0x21)*94+int(c2-0x21)])
+	}
+
+	return builder.String()
+}
+
+func jisx4081FindFile(dir string, names ...string) (string, error) {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return "", err
+	}
+
+	for _, name := range names {
+		for _, file := range files {
+			fileName := strings.ToUpper(file.Name())
+			fileName = strings.TrimSuffix(fileName, ";1")
+			fileName = strings.TrimSuffix(fileName, ".")
+			if fileName == name {
+				return filepath.Join(dir, file.Name()), nil
+			}
+		}
+	}
+
+	return "", fmt.Errorf("failed to find '%s' in '%s'", strings.Join(names, "' or '"), dir)
+}
+
+func jisx4081ReadCatalog(path string) ([]jisx4081Subbook, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(data) < 16 {
+		return nil, errors.New("catalog file is truncated")
+	}
+
+	count := int(binary.BigEndian.Uint16(data))
+	if len(data) < 16+count*jisx4081CatalogSize {
+		return nil, errors.New("catalog file is truncated")
+	}
+
+	var subbooks []jisx4081Subbook
+	for i := 0; i < count; i++ {
+		entry := data[16+i*jisx4081CatalogSize : 16+(i+1)*jisx4081CatalogSize]
+
+		title := entry[2 : 2+jisx4081TitleSize]
+		if index := bytes.IndexByte(title, 0); index >= 0 {
+			title = title[:index]
+		}
+
+		directory := entry[2+jisx4081TitleSize : 2+jisx4081TitleSize+jisx4081DirectorySize]
+		if index := bytes.IndexByte(directory, 0); index >= 0 {
+			directory = directory[:index]
+		}
+
+		indexPage := int(binary.BigEndian.Uint16(entry[2+jisx4081TitleSize+jisx4081DirectorySize+4:]))
+		if indexPage == 0 {
+			indexPage = 1
+		}
+
+		subbooks = append(subbooks, jisx4081Subbook{
+			title:     strings.TrimRight(jisx4081DecodeJis(title), " 　"),
+			directory: strings.TrimSpace(string(directory)),
+			indexPage: indexPage,
+		})
+	}
+
+	return subbooks, nil
+}
+
+func (s *jisx4081Subbook) readIndices() ([]jisx4081Index, error) {
+	start := jisx4081Position(s.indexPage, 0)
+	if start < 0 || start+jisx4081PageSize > len(s.text) {
+		return nil, errors.New("index page is out of range")
+	}
+
+	page := s.text[start : start+jisx4081PageSize]
+	count := int(page[1])
+
+	var indices []jisx4081Index
+	for i := 0; i < count && 16+(i+1)*16 <= jisx4081PageSize; i++ {
+		entry := page[16+i*16 : 16+(i+1)*16]
+		indices = append(indices, jisx4081Index{
+			id:        entry[0],
+			startPage: int(binary.BigEndian.Uint32(entry[2:])),
+			pageCount: int(binary.BigEndian.Uint32(entry[6:])),
+		})
+	}
+
+	return indices, nil
+}
+
+func (s *jisx4081Subbook) readLeafEntries(index jisx4081Index, visit func(heading, text int) error) error {
+	readPosition := func(data []byte) int {
+		return jisx4081Position(int(binary.BigEndian.Uint32(data)), int(binary.BigEndian.Uint16(data[4:])))
+	}
+
+	for page := index.startPage; page < index.startPage+index.pageCount; page++ {
+		start := jisx4081Position(page, 0)
+		if start < 0 {
+			return fmt.Errorf("leaf page %d is out of range in subbook '%s'", page, s.title)
+		}
+		if start+jisx4081PageSize > len(s.text) {
+			break
+		}
+
+		data := s.text[start : start+jisx4081PageSize]
+		pageId := data[0]
+		if pageId&jisx4081PageLeaf == 0 {
+			continue
+		}
+
+		count := int(binary.BigEndian.Uint16(data[2:]))
+		offset := 4
+
+		for i := 0; i < count && offset < len(data); i++ {
+			if pageId&jisx4081PageGroupEntry == 0 {
+				length := int(data[offset])
+				if offset+length+13 > len(data) {
+					break
+				}
+
+				if err := visit(readPosition(data[offset+length+7:]), readPosition(data[offset+length+1:])); err != nil {
+					return err
+				}
+				offset += length + 13
+				continue
+			}
+
+			if offset+2 > len(data) {
+				break
+			}
+
+			length := int(data[offset+1])
+			switch data[offset] {
+			case jisx4081GroupSingle, jisx4081GroupElement:
+				if offset+length+14 > len(data) {
+					return nil
+				}
+
+				if err := visit(readPosition(data[offset+length+8:]), readPosition(data[offset+length+2:])); err != nil {
+					return err
+				}
+				offset += length + 14
+			case jisx4081GroupStart:
+				offset += length + 4
+			default:
+				return nil
+			}
+		}
+	}
+
+	return nil
+}
+
+func (s *jisx4081Subbook) readMedia(kind string, position int, extra []int) (string, error) {
+	key := fmt.Sprintf("%s:%d", kind, position)
+	if id, ok := s.mediaIds[key]; ok {
+		return fmt.Sprintf("{{m_%d}}", id), nil
+	}
+
+	if err := s.checkPosition(position); err != nil {
+		return "", err
+	}
+
+	var (
+		media epwingMedia
+		err   error
+	)
+
+	switch kind {
+	case "color":
+		if position+8 > len(s.text) || string(s.text[position:position+4]) != "data" {
+			log.Printf("skipping unreadable graphic in '%s'\n", s.title)
+			return "", nil
+		}
+
+		size := int(binary.LittleEndian.Uint32(s.text[position+4:]))
+		if size < 0 || position+8+size > len(s.text) {
+			return "", fmt.Errorf("graphic at %d is out of range in subbook '%s'", position, s.title)
+		}
+
+		media.Data = s.text[position+8 : position+8+size]
+		switch {
+		case bytes.HasPrefix(media.Data, []byte("BM")):
+			media.Type = "bmp"
+		case bytes.HasPrefix(media.Data, []byte{0xff, 0xd8}):
+			media.Type = "jpeg"
+		default:
+			media.Type = "unknown"
+		}
+	case "mono":
+		media.Type = "bmp"
+		media.Data, err = jisx4081BuildBitmap(s.text, position, extra[0], extra[1])
+	}
+
+	if err != nil {
+		return "", fmt.Errorf("%s in subbook '%s'", err.Error(), s.title)
+	}
+
+	id := len(s.book.Media)
+	s.book.Media = append(s.book.Media, media)
+	s.mediaIds[key] = id
+
+	return fmt.Sprintf("{{m_%d}}", id), nil
+}
+
+func jisx4081BuildBitmap(data []byte, position, width, height int) ([]byte, error) {
+	stride := (width + 7) / 8
+	if width <= 0 || height <= 0 {
+		return nil, fmt.Errorf("invalid bitmap size %dx%d", width, height)
+	}
+	if position < 0 || position+stride*height > len(data) {
+		return nil, fmt.Errorf("bitmap at %d is out of range", position)
+	}
+
+	rowSize := (stride + 3) &^ 3
+	pixelOffset := 14 + 40 + 8
+
+	var buffer bytes.Buffer
+	buffer.WriteString("BM")
+	binary.Write(&buffer, binary.LittleEndian, []uint32{uint32(pixelOffset + rowSize*height), 0, uint32(pixelOffset)})
+	binary.Write(&buffer, binary.LittleEndian, []uint32{40, uint32(width), uint32(height)})
+	binary.Write(&buffer, binary.LittleEndian, []uint16{1, 1})
+	binary.Write(&buffer, binary.LittleEndian, []uint32{0, uint32(rowSize * height), 2835, 2835, 2, 0})
+	buffer.Write([]byte{0xff, 0xff, 0xff, 0x00, 0x00, 0x00, 0x00, 0x00})
+
+	padding := make([]byte, rowSize-stride)
+	for row := height - 1; row >= 0; row-- {
+		buffer.Write(data[position+row*stride : position+(row+1)*stride])
+		buffer.Write(padding)
+	}
+
+	return buffer.Bytes(), nil
+}
+
+func (s *jisx4081Subbook) readText(position int, heading bool) (string, error) {
+	if err := s.checkPosition(position); err != nil {
+		return "", err
+	}
+
+	limit := position + jisx4081MaxTextSize
+	if heading {
+		limit = position + jisx4081MaxHeadingSize
+	}
+	if limit > len(s.text) {
+		limit = len(s.text)
+	}
+
+	var (
+		builder   strings.Builder
+		narrow    bool
+		stopCode  = -1
+		mono      []int
+		printable int
+	)
+
+	for i := position; i+1 < limit; {
+		c1, c2 := s.text[i], s.text[i+1]
+
+		if c1 != jisx4081EscapeCharacter {
+			i += 2
+
+			switch {
+			case c1 >= 0x21 && c1 <= 0x7e && c2 >= 0x21 && c2 <= 0x7e:
+				if narrow && c1 == 0x23 {
+					builder.WriteByte(c2)
+				} else if narrow && c1 == 0x21 && c2 == 0x21 {
+					builder.WriteByte(' ')
+				} else {
+					builder.WriteString(jisx4081DecodeJis([]byte{c1, c2}))
+				}
+			case c1 >= 0xa1 && c2 >= 0x21 && c2 <= 0x7e:
+				if narrow {
+					fmt.Fprintf(&builder, "{{n_%d}}", int(c1)<<8|int(c2))
+				} else {
+					fmt.Fprintf(&builder, "{{w_%d}}", int(c1)<<8|int(c2))
+				}
+			default:
+				continue
+			}
+
+			printable++
+			continue
+		}
+
+		size, ok := jisx4081EscapeSizes[c2]
+		if !ok {
+			size = 2
+		}
+		if i+size > limit {
+			break
+		}
+
+		args := s.text[i : i+size]
+		i += size
+
+		switch c2 {
+		case 0x03:
+			return builder.String(), nil
+		case 0x04:
+			narrow = true
+		case 0x05:
+			narrow = false
+		case 0x0a:
+			if heading {
+				return builder.String(), nil
+			}
+			builder.WriteByte('\n')
+		case 0x41:
+			code := int(binary.BigEndian.Uint16(args[2:]))
+			if stopCode < 0 {
+				stopCode = code
+			} else if code == stopCode && printable > 0 {
+				return builder.String(), nil
+			}
+		case 0x3c, 0x4d:
+			if s.media {
+				marker, err := s.readMedia("color", jisx4081Position(jisx4081DecodeBCD(args[14:18]), jisx4081DecodeBCD(args[18:20])), nil)
+				if err != nil {
+					return "", err
+				}
+				builder.WriteString(marker)
+			}
+		case 0x44:
+			mono = []int{jisx4081DecodeBCD(args[10:12]), jisx4081DecodeBCD(args[8:10])}
+		case 0x64:
+			if s.media && mono != nil {
+				marker, err := s.readMedia("mono", jisx4081Position(jisx4081DecodeBCD(args[2:6]), jisx4081DecodeBCD(args[6:8])), mono)
+				if err != nil {
+					return "", err
+				}
+				builder.WriteString(marker)
+			}
+			mono = nil
+		case 0x4a:
+			// Sound references are skipped without decoding the audio, as
+			// glossaries have no way to play it back.
+		}
+	}
+
+	return builder.String(), nil
+}
+
+func (s *jisx4081Subbook) readEntries() ([]epwingEntry, error) {
+	indices, err := s.readIndices()
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		entries []epwingEntry
+		visited = make(map[[2]int]bool)
+		found   bool
+	)
+
+	for _, index := range indices {
+		switch index.id {
+		case jisx4081IndexCopyright:
+			if s.book.Copyright, err = s.readText(jisx4081Position(index.startPage, 0), false); err != nil {
+				return nil, err
+			}
+		case jisx4081IndexWordAsis, jisx4081IndexWordKana, jisx4081IndexWordLatin:
+			found = true
+			err = s.readLeafEntries(index, func(heading, text int) error {
+				key := [2]int{heading, text}
+				if visited[key] {
+					return nil
+				}
+
+				visited[key] = true
+
+				var (
+					entry epwingEntry
+					err   error
+				)
+
+				if entry.Heading, err = s.readText(heading, true); err != nil {
+					return err
+				}
+				if entry.Text, err = s.readText(text, false); err != nil {
+					return err
+				}
+
+				entries = append(entries, entry)
+				return nil
+			})
+
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if !found {
+		return nil, fmt.Errorf("subbook '%s' does not have a word search index", s.title)
+	}
+
+	return entries, nil
+}
+
+func jisx4081ReadBook(path string, media bool) (epwingBook, error) {
+	book := epwingBook{CharCode: "jisx0208", DiscCode: "epwing"}
+
+	catalogPath, err := jisx4081FindFile(path, "CATALOGS")
+	if err != nil {
+		return book, err
+	}
+
+	subbooks, err := jisx4081ReadCatalog(catalogPath)
+	if err != nil {
+		return book, err
+	}
+
+	for _, subbook := range subbooks {
+		subbookPath, err := jisx4081FindFile(path, strings.ToUpper(subbook.directory))
+		if err != nil {
+			return book, err
+		}
+
+		dataPath, err := jisx4081FindFile(subbookPath, "DATA")
+		if err != nil {
+			return book, err
+		}
+
+		textPath, err := jisx4081FindFile(dataPath, "HONMON")
+		if err != nil {
+			if _, errCompressed := jisx4081FindFile(dataPath, "HONMON2", "HONMON.EBZ"); errCompressed == nil {
+				return book, fmt.Errorf("compressed text in subbook '%s' is not supported", subbook.title)
+			}
+
+			return book, err
+		}
+
+		log.Printf("reading subbook '%s' from '%s'...\n", subbook.title, textPath)
+		if subbook.text, err = ioutil.ReadFile(textPath); err != nil {
+			return book, err
+		}
+
+		subbook.media = media
+		subbook.mediaIds = make(map[string]int)
+		subbook.book = &epwingSubbook{Title: subbook.title}
+
+		if subbook.book.Entries, err = subbook.readEntries(); err != nil {
+			return book, err
+		}
+
+		book.Subbooks = append(book.Subbooks, *subbook.book)
+	}
+
+	return book, nil
+}
@@ -0,0 +1,72 @@
+/*
+ * Copyright (c) 2016 Alex Yatskov <alex@foosoft.net>
+ * Author: Alex Yatskov <alex@foosoft.net>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of
+ * this software and associated documentation files (the "Software"), to deal in
+ * the Software without restriction, including without limitation the rights to
+ * use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+ * the Software, and to permit persons to whom the Software is furnished to do so,
+ * subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+ * FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+ * COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+ * IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+ * CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+package main
+
+import (
+	"bytes"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestJisx4081ReadBook(t *testing.T) {
+	book, err := jisx4081ReadBook(filepath.Join("testdata", "jisx4081", "disc"), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	compareGolden(t, filepath.Join("testdata", "jisx4081", "book.golden"), book)
+}
+
+func TestJisx4081OutOfRange(t *testing.T) {
+	subbook := jisx4081Subbook{
+		title:    "test",
+		text:     make([]byte, jisx4081PageSize),
+		mediaIds: make(map[string]int),
+		book:     &epwingSubbook{},
+	}
+
+	for _, position := range []int{jisx4081Position(0, 0), jisx4081PageSize} {
+		if _, err := subbook.readText(position, false); err == nil || !strings.Contains(err.Error(), "out of range") {
+			t.Errorf("expected an out of range error reading text at %d, got %v", position, err)
+		}
+
+		if _, err := subbook.readMedia("mono", position, []int{8, 8}); err == nil {
+			t.Errorf("expected an error reading media at %d", position)
+		}
+	}
+
+	if _, err := jisx4081BuildBitmap(subbook.text, -1, 8, 8); err == nil {
+		t.Error("expected an error building a bitmap at a negative position")
+	}
+}
+
+func TestJisx4081BuildBitmap(t *testing.T) {
+	bitmap, err := jisx4081BuildBitmap([]byte{0xff, 0x00, 0x00, 0xff}, 0, 16, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.HasPrefix(bitmap, []byte("BM")) || !bytes.HasSuffix(bitmap, []byte{0x00, 0xff, 0x00, 0x00, 0xff, 0x00, 0x00, 0x00}) {
+		t.Errorf("unexpected bitmap %v", bitmap)
+	}
+}
@@ -23,15 +23,252 @@
 package main
 
 import (
-	"os"
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
 	"strconv"
+	"strings"
+	"unicode/utf8"
 
 	"github.com/FooSoft/jmdict"
+	"golang.org/x/text/encoding/japanese"
 )
 
 const kanjidicRevision = "kanjidic2"
 
-func kanjidicExtractKanji(entry jmdict.KanjidicCharacter, language string) *dbKanji {
+// Each extra kind adds a stat of the same name, except for radical, which
+// adds rad_classical and rad_nelson, and korean, which adds korean_r and
+// korean_h.
+var kanjidicExtraKinds = []string{"nanori", "rad_name", "radical", "variant", "pinyin", "korean", "vietnam"}
+
+var kanjidicLanguageNames = map[string]string{
+	"english":    "en",
+	"french":     "fr",
+	"spanish":    "es",
+	"portuguese": "pt",
+	"eng":        "en",
+	"fre":        "fr",
+	"fra":        "fr",
+	"spa":        "es",
+	"por":        "pt",
+}
+
+type kanjidicOptions struct {
+	languages []string
+	labels    bool
+	extras    map[string]bool
+	variants  map[string][]kanjidicVariant
+}
+
+type kanjidicVariant struct {
+	variantType string
+	value       string
+}
+
+// The jmdict package decodes var_type as an element rather than an
+// attribute, so variants are recovered here with a separate pass over the
+// raw XML, keyed by character literal.
+func kanjidicLoadVariants(reader io.Reader) (map[string][]kanjidicVariant, error) {
+	decoder := xml.NewDecoder(reader)
+
+	var (
+		variants = make(map[string][]kanjidicVariant)
+		literal  string
+	)
+
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+
+		element, ok := token.(xml.StartElement)
+		if !ok {
+			continue
+		}
+
+		switch element.Name.Local {
+		case "literal":
+			if err := decoder.DecodeElement(&literal, &element); err != nil {
+				return nil, err
+			}
+		case "variant":
+			var variant kanjidicVariant
+			for _, attr := range element.Attr {
+				if attr.Name.Local == "var_type" {
+					variant.variantType = attr.Value
+				}
+			}
+
+			if err := decoder.DecodeElement(&variant.value, &element); err != nil {
+				return nil, err
+			}
+
+			variants[literal] = append(variants[literal], variant)
+		}
+	}
+
+	return variants, nil
+}
+
+// Kuten codes are decoded through EUC-JP, which covers JIS X 0208 and JIS X
+// 0212. JIS X 0213 codes are only decoded on plane 1, where they coincide
+// with JIS X 0208 for every character that standard defines.
+func kanjidicDecodeVariant(variant kanjidicVariant) (string, bool) {
+	if variant.variantType == "ucs" {
+		value, err := strconv.ParseInt(variant.value, 16, 32)
+		if err != nil || !utf8.ValidRune(rune(value)) {
+			return "", false
+		}
+
+		return string(rune(value)), true
+	}
+
+	parts := strings.Split(variant.value, "-")
+	if len(parts) < 2 || len(parts) > 3 {
+		return "", false
+	}
+
+	var numbers []int
+	for _, part := range parts {
+		number, err := strconv.Atoi(part)
+		if err != nil {
+			return "", false
+		}
+
+		numbers = append(numbers, number)
+	}
+
+	plane, row, cell := 1, numbers[len(numbers)-2], numbers[len(numbers)-1]
+	if len(numbers) == 3 {
+		plane = numbers[0]
+	}
+	if row < 1 || row > 94 || cell < 1 || cell > 94 {
+		return "", false
+	}
+
+	data := []byte{byte(0xa0 + row), byte(0xa0 + cell)}
+	switch variant.variantType {
+	case "jis208":
+	case "jis212":
+		data = append([]byte{0x8f}, data...)
+	case "jis213":
+		if plane != 1 {
+			return "", false
+		}
+	default:
+		return "", false
+	}
+
+	decoded, err := japanese.EUCJP.NewDecoder().Bytes(data)
+	if err != nil {
+		return "", false
+	}
+
+	character, size := utf8.DecodeRune(decoded)
+	if character == utf8.RuneError || size != len(decoded) {
+		return "", false
+	}
+
+	return string(decoded), true
+}
+
+func kanjidicParseLanguages(language string) ([]string, error) {
+	var codes []string
+	for _, name := range strings.Split(language, ",") {
+		name = strings.TrimSpace(name)
+		if len(name) == 0 {
+			continue
+		}
+
+		code, ok := kanjidicLanguageNames[name]
+		if !ok {
+			if len(name) != 2 {
+				return nil, fmt.Errorf("unrecognized language '%s'", name)
+			}
+
+			code = name
+		}
+
+		codes = appendStringUnique(codes, code)
+	}
+
+	if len(codes) == 0 {
+		codes = append(codes, "en")
+	}
+
+	return codes, nil
+}
+
+func kanjidicMeaningLanguage(language *string) string {
+	if language == nil {
+		return "en"
+	}
+
+	return *language
+}
+
+func kanjidicExtractExtras(kanji *dbKanji, entry jmdict.KanjidicCharacter, options kanjidicOptions) {
+	extras := options.extras
+
+	if extras["nanori"] && len(entry.ReadingMeaning.Nanori) > 0 {
+		kanji.Stats["nanori"] = strings.Join(entry.ReadingMeaning.Nanori, "、")
+	}
+
+	if extras["rad_name"] && len(entry.Misc.RadicalName) > 0 {
+		kanji.Stats["rad_name"] = strings.Join(entry.Misc.RadicalName, "、")
+	}
+
+	if extras["radical"] {
+		for _, radical := range entry.Radical {
+			switch radical.Type {
+			case "classical":
+				kanji.Stats["rad_classical"] = radical.Value
+			case "nelson_c":
+				kanji.Stats["rad_nelson"] = radical.Value
+			}
+		}
+	}
+
+	if extras["variant"] {
+		var variants []string
+		for _, variant := range options.variants[entry.Literal] {
+			if character, ok := kanjidicDecodeVariant(variant); ok {
+				variants = appendStringUnique(variants, character)
+			} else {
+				variants = appendStringUnique(variants, variant.variantType+": "+variant.value)
+			}
+		}
+
+		if len(variants) > 0 {
+			kanji.Stats["variant"] = strings.Join(variants, ", ")
+		}
+	}
+
+	readings := make(map[string][]string)
+	for _, r := range entry.ReadingMeaning.Readings {
+		switch r.Type {
+		case "pinyin", "vietnam":
+			if extras[r.Type] {
+				readings[r.Type] = append(readings[r.Type], r.Value)
+			}
+		case "korean_r", "korean_h":
+			if extras["korean"] {
+				readings[r.Type] = append(readings[r.Type], r.Value)
+			}
+		}
+	}
+
+	for name, values := range readings {
+		kanji.Stats[name] = strings.Join(values, ", ")
+	}
+}
+
+func kanjidicExtractKanji(entry jmdict.KanjidicCharacter, options kanjidicOptions) *dbKanji {
 	if entry.ReadingMeaning == nil {
 		return nil
 	}
@@ -41,9 +278,17 @@ func kanjidicExtractKanji(entry jmdict.KanjidicCharacter, language string) *dbKa
 		Stats:     make(map[string]string),
 	}
 
-	for _, m := range entry.ReadingMeaning.Meanings {
-		if m.Language == nil && language == "" || m.Language != nil && language == *m.Language {
-			kanji.Meanings = append(kanji.Meanings, m.Meaning)
+	for _, language := range options.languages {
+		for _, m := range entry.ReadingMeaning.Meanings {
+			if kanjidicMeaningLanguage(m.Language) != language {
+				continue
+			}
+
+			if options.labels {
+				kanji.Meanings = append(kanji.Meanings, fmt.Sprintf("[%s] %s", language, m.Meaning))
+			} else {
+				kanji.Meanings = append(kanji.Meanings, m.Meaning)
+			}
 		}
 	}
 
@@ -95,35 +340,102 @@ func kanjidicExtractKanji(entry jmdict.KanjidicCharacter, language string) *dbKa
 		}
 	}
 
+	kanjidicExtractExtras(&kanji, entry, options)
+
 	return &kanji
 }
 
-func kanjidicExportDb(inputPath, outputPath, language, title string, stride int, pretty bool) error {
-	reader, err := os.Open(inputPath)
+func kanjidicBuildFrequencies(kanji dbKanjiList) dbMetaList {
+	var frequencies dbMetaList
+	for _, entry := range kanji {
+		if frequency, err := strconv.Atoi(entry.Stats["freq"]); err == nil {
+			frequencies = append(frequencies, dbMeta{entry.Character, "freq", frequency})
+		}
+	}
+
+	return frequencies
+}
+
+func kanjidicExportDb(inputPath, outputPath, language, title string, stride int, pretty bool, options exportOptions) error {
+	reader, err := openInput(inputPath)
 	if err != nil {
 		return err
 	}
 	defer reader.Close()
 
-	dict, err := jmdict.LoadKanjidic(reader)
+	data, err := ioutil.ReadAll(reader)
+	if err != nil {
+		return err
+	}
+
+	dict, err := jmdict.LoadKanjidic(bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+
+	languages, err := kanjidicParseLanguages(language)
+	if err != nil {
+		return err
+	}
+
+	available := make(map[string]bool)
+	for _, entry := range dict.Characters {
+		if entry.ReadingMeaning == nil {
+			continue
+		}
+
+		for _, m := range entry.ReadingMeaning.Meanings {
+			available[kanjidicMeaningLanguage(m.Language)] = true
+		}
+	}
+
+	if err := jmdictCheckLanguages(languages, available); err != nil {
+		return err
+	}
+
+	extras, err := parseKinds(options.kanjidicExtras, "extra", kanjidicExtraKinds)
 	if err != nil {
 		return err
 	}
 
-	var langTag string
-	switch language {
-	case "french":
-		langTag = "fr"
-	case "spanish":
-		langTag = "es"
-	case "portuguese":
-		langTag = "pt"
+	kanjidicOpts := kanjidicOptions{
+		languages: languages,
+		labels:    options.languageLabels,
+		extras:    extras,
+	}
+
+	if extras["variant"] {
+		if kanjidicOpts.variants, err = kanjidicLoadVariants(bytes.NewReader(data)); err != nil {
+			return err
+		}
+	}
+
+	var components map[string][]string
+	if len(options.kradfilePaths) > 0 {
+		if components, err = kradfileLoad(options.kradfilePaths); err != nil {
+			return err
+		}
+	}
+
+	var lists *kanjiLists
+	if len(options.kanjiListPaths) > 0 {
+		if lists, err = kanjiListsLoad(options.kanjiListPaths); err != nil {
+			return err
+		}
 	}
 
 	var kanji dbKanjiList
 	for _, entry := range dict.Characters {
-		kanjiCurr := kanjidicExtractKanji(entry, langTag)
+		kanjiCurr := kanjidicExtractKanji(entry, kanjidicOpts)
 		if kanjiCurr != nil {
+			if parts, ok := components[kanjiCurr.Character]; ok {
+				kanjiCurr.Stats["components"] = strings.Join(parts, " ")
+			}
+
+			if lists != nil {
+				lists.apply(kanjiCurr)
+			}
+
 			kanji = append(kanji, *kanjiCurr)
 		}
 	}
@@ -140,6 +452,15 @@ func kanjidicExportDb(inputPath, outputPath, language, title string, stride int,
 		dbTag{Name: "grade", Notes: "Grade level", Category: "misc"},
 		dbTag{Name: "jlpt", Notes: "JLPT level", Category: "misc"},
 		dbTag{Name: "strokes", Notes: "Stroke count", Category: "misc"},
+		dbTag{Name: "rad_name", Notes: "Radical name", Category: "misc"},
+		dbTag{Name: "variant", Notes: "Variant characters", Category: "misc"},
+		dbTag{Name: "components", Notes: "Radical components (KRADFILE)", Category: "misc"},
+
+		dbTag{Name: "nanori", Notes: "Readings used in names", Category: "misc"},
+		dbTag{Name: "pinyin", Notes: "Mandarin Chinese reading (pinyin)", Category: "misc"},
+		dbTag{Name: "korean_r", Notes: "Korean reading (romanized)", Category: "misc"},
+		dbTag{Name: "korean_h", Notes: "Korean reading (hangul)", Category: "misc"},
+		dbTag{Name: "vietnam", Notes: "Vietnamese reading", Category: "misc"},
 
 		dbTag{Name: "jis208", Notes: "JIS X 0208-1997 kuten code", Category: "code"},
 		dbTag{Name: "jis212", Notes: "JIS X 0212-1990 kuten code", Category: "code"},
@@ -148,6 +469,8 @@ func kanjidicExportDb(inputPath, outputPath, language, title string, stride int,
 
 		dbTag{Name: "deroo", Notes: "2001 Kanji", Category: "class"},
 		dbTag{Name: "four_corner", Notes: "Four corner code", Category: "class"},
+		dbTag{Name: "rad_classical", Notes: "Classical (Kangxi) radical number", Category: "class"},
+		dbTag{Name: "rad_nelson", Notes: "Nelson radical number", Category: "class"},
 		dbTag{Name: "misclass", Notes: "Misclassification", Category: "class"},
 		dbTag{Name: "sh_desc", Notes: "The Kanji Dictionary", Category: "class"},
 		dbTag{Name: "skip", Notes: "SKIP code", Category: "class"},
@@ -178,18 +501,57 @@ func kanjidicExportDb(inputPath, outputPath, language, title string, stride int,
 		dbTag{Name: "tutt_cards", Notes: "Tuttle Kanji Cards", Category: "index"},
 	}
 
+	if lists != nil {
+		tags = lists.buildTagMeta(tags)
+	}
+
 	recordData := map[string]dbRecordList{
 		"kanji": kanji.crush(),
-		"tag":   tags.crush(),
 	}
 
-	return writeDb(
+	if options.kanjidicFrequency {
+		recordData["kanji_meta"] = kanjidicBuildFrequencies(kanji).crush()
+	}
+
+	var images map[string][]byte
+	if len(options.kanjivgPath) > 0 {
+		if images, err = kanjivgLoad(options.kanjivgPath); err != nil {
+			return err
+		}
+	}
+
+	recordData["tag"] = tags.crush()
+
+	revision := kanjidicRevision
+	var description string
+	if header := dict.Header; header.DateOfCreation != "" {
+		revision += "." + header.DateOfCreation
+		description = fmt.Sprintf(
+			"KANJIDIC2 file version %s, database version %s, created %s, converted with format %s",
+			header.FileVersion,
+			header.DatabaseVersion,
+			header.DateOfCreation,
+			kanjidicRevision,
+		)
+	}
+
+	if err := writeDb(
 		outputPath,
 		title,
-		kanjidicRevision,
+		revision,
+		description,
 		false,
 		recordData,
+		nil,
 		stride,
 		pretty,
-	)
+	); err != nil {
+		return err
+	}
+
+	if images == nil {
+		return nil
+	}
+
+	return kanjivgWriteDb(outputPath, title, kanji, images, stride, pretty)
 }
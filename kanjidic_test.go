@@ -0,0 +1,305 @@
+/*
+ * Copyright (c) 2016 Alex Yatskov <alex@foosoft.net>
+ * Author: Alex Yatskov <alex@foosoft.net>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of
+ * this software and associated documentation files (the "Software"), to deal in
+ * the Software without restriction, including without limitation the rights to
+ * use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+ * the Software, and to permit persons to whom the Software is furnished to do so,
+ * subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+ * FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+ * COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+ * IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+ * CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/FooSoft/jmdict"
+)
+
+func loadKanjidicFixture(t *testing.T) jmdict.Kanjidic {
+	t.Helper()
+
+	reader, err := os.Open(filepath.Join("testdata", "kanjidic", "kanjidic2.xml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reader.Close()
+
+	dict, err := jmdict.LoadKanjidic(reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return dict
+}
+
+func findKanjidicCharacter(t *testing.T, dict jmdict.Kanjidic, literal string) jmdict.KanjidicCharacter {
+	t.Helper()
+
+	for _, character := range dict.Characters {
+		if character.Literal == literal {
+			return character
+		}
+	}
+
+	t.Fatalf("character %s not found", literal)
+	return jmdict.KanjidicCharacter{}
+}
+
+func TestKanjidicExtras(t *testing.T) {
+	dict := loadKanjidicFixture(t)
+
+	extras, err := parseKinds("all", "extra", kanjidicExtraKinds)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	reader, err := os.Open(filepath.Join("testdata", "kanjidic", "kanjidic2.xml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reader.Close()
+
+	variants, err := kanjidicLoadVariants(reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	kanji := kanjidicExtractKanji(findKanjidicCharacter(t, dict, "日"), kanjidicOptions{languages: []string{"en"}, extras: extras, variants: variants})
+	expected := map[string]string{
+		"nanori":        "あき、か",
+		"rad_classical": "72",
+		"pinyin":        "ri4",
+		"korean_r":      "il",
+		"korean_h":      "일",
+		"vietnam":       "Nhật",
+	}
+	for name, value := range expected {
+		if kanji.Stats[name] != value {
+			t.Errorf("stat '%s' is '%s', expected '%s'", name, kanji.Stats[name], value)
+		}
+	}
+
+	kanji = kanjidicExtractKanji(findKanjidicCharacter(t, dict, "亜"), kanjidicOptions{languages: []string{"en"}, extras: extras, variants: variants})
+	if kanji.Stats["rad_nelson"] != "1" || kanji.Stats["variant"] != "亞" {
+		t.Errorf("unexpected stats %v", kanji.Stats)
+	}
+
+	kanji = kanjidicExtractKanji(findKanjidicCharacter(t, dict, "乂"), kanjidicOptions{languages: []string{"en"}, extras: extras, variants: variants})
+	if kanji.Stats["rad_name"] != "はらいぼう" || kanji.Stats["variant"] != "丂, 刈, jis213: 2-1-1, nelson_c: 43" {
+		t.Errorf("unexpected stats %v", kanji.Stats)
+	}
+
+	extras, err = parseKinds("nanori", "extra", kanjidicExtraKinds)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	kanji = kanjidicExtractKanji(findKanjidicCharacter(t, dict, "日"), kanjidicOptions{languages: []string{"en"}, extras: extras, variants: variants})
+	if _, ok := kanji.Stats["pinyin"]; ok || kanji.Stats["nanori"] == "" {
+		t.Errorf("unexpected stats %v", kanji.Stats)
+	}
+
+	if _, err := parseKinds("nanori,mongolian", "extra", kanjidicExtraKinds); err == nil {
+		t.Error("expected an unknown extra kind to fail")
+	}
+
+	extras, err = parseKinds("", "extra", kanjidicExtraKinds)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	kanji = kanjidicExtractKanji(findKanjidicCharacter(t, dict, "日"), kanjidicOptions{languages: []string{"en"}, extras: extras, variants: variants})
+	for _, name := range []string{"nanori", "rad_classical", "pinyin", "korean_r", "vietnam"} {
+		if _, ok := kanji.Stats[name]; ok {
+			t.Errorf("stat '%s' should not be included by default", name)
+		}
+	}
+}
+
+func TestKanjidicDecodeVariant(t *testing.T) {
+	cases := []struct {
+		variant   kanjidicVariant
+		character string
+	}{
+		{variant: kanjidicVariant{"jis208", "1-48-19"}, character: "亞"},
+		{variant: kanjidicVariant{"jis208", "48-19"}, character: "亞"},
+		{variant: kanjidicVariant{"jis212", "1-16-01"}, character: "丂"},
+		{variant: kanjidicVariant{"jis213", "1-48-19"}, character: "亞"},
+		{variant: kanjidicVariant{"ucs", "4e9e"}, character: "亞"},
+		{variant: kanjidicVariant{"jis208", "2-15"}},
+		{variant: kanjidicVariant{"jis208", "95-01"}},
+		{variant: kanjidicVariant{"jis213", "2-1-1"}},
+		{variant: kanjidicVariant{"deroo", "1234"}},
+	}
+
+	for _, c := range cases {
+		character, ok := kanjidicDecodeVariant(c.variant)
+		if ok != (len(c.character) > 0) || character != c.character {
+			t.Errorf("%+v decoded as '%s' (%t), expected '%s'", c.variant, character, ok, c.character)
+		}
+	}
+}
+
+func TestKanjidicLanguages(t *testing.T) {
+	cases := []struct {
+		input    string
+		expected []string
+		fails    bool
+	}{
+		{input: "", expected: []string{"en"}},
+		{input: "english", expected: []string{"en"}},
+		{input: "french,spa, pt", expected: []string{"fr", "es", "pt"}},
+		{input: "fre,fr", expected: []string{"fr"}},
+		{input: "de", expected: []string{"de"}},
+		{input: "klingon", fails: true},
+	}
+
+	for _, c := range cases {
+		languages, err := kanjidicParseLanguages(c.input)
+		if c.fails {
+			if err == nil {
+				t.Errorf("expected '%s' to fail", c.input)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("unexpected error for '%s': %s", c.input, err.Error())
+		} else if !reflect.DeepEqual(languages, c.expected) {
+			t.Errorf("'%s' parsed as %v, expected %v", c.input, languages, c.expected)
+		}
+	}
+
+	dict := loadKanjidicFixture(t)
+	kanji := kanjidicExtractKanji(findKanjidicCharacter(t, dict, "日"), kanjidicOptions{languages: []string{"fr", "en"}, labels: true})
+	expected := []string{"[fr] jour", "[fr] soleil", "[en] day", "[en] sun", "[en] Japan"}
+	if !reflect.DeepEqual(kanji.Meanings, expected) {
+		t.Errorf("got meanings %v, expected %v", kanji.Meanings, expected)
+	}
+
+	if kanji := kanjidicExtractKanji(findKanjidicCharacter(t, dict, "乂"), kanjidicOptions{languages: []string{"fr"}}); kanji != nil {
+		t.Errorf("expected no French entry for 乂, got %+v", kanji)
+	}
+
+	dir, err := ioutil.TempDir("", "yomichan_test_")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	err = kanjidicExportDb(filepath.Join("testdata", "kanjidic", "kanjidic2.xml"), filepath.Join(dir, "unused.zip"), "de", "", defaultStride, false, exportOptions{})
+	if err == nil || !strings.Contains(err.Error(), "not present") {
+		t.Errorf("expected missing language error, got %v", err)
+	}
+}
+
+func TestKanjidicExportFrequencies(t *testing.T) {
+	dir, err := ioutil.TempDir("", "yomichan_test_")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	outputPath := filepath.Join(dir, "kanjidic.zip")
+	if err := kanjidicExportDb(filepath.Join("testdata", "kanjidic", "kanjidic2.xml"), outputPath, "", "", defaultStride, false, exportOptions{kanjidicFrequency: true}); err != nil {
+		t.Fatal(err)
+	}
+
+	var frequencies []interface{}
+	if err := json.Unmarshal(readZipFile(t, outputPath, "kanji_meta_bank_1.json"), &frequencies); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []interface{}{
+		[]interface{}{"日", "freq", 1.0},
+		[]interface{}{"亜", "freq", 1509.0},
+	}
+	if !reflect.DeepEqual(frequencies, expected) {
+		t.Errorf("got frequencies %v, expected %v", frequencies, expected)
+	}
+}
+
+func TestKanjidicExportRevision(t *testing.T) {
+	dir, err := ioutil.TempDir("", "yomichan_test_")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	outputPath := filepath.Join(dir, "kanjidic.zip")
+	if err := kanjidicExportDb(filepath.Join("testdata", "kanjidic", "kanjidic2.xml"), outputPath, "", "", defaultStride, false, exportOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	var index struct {
+		Revision    string `json:"revision"`
+		Description string `json:"description"`
+	}
+	if err := json.Unmarshal(readZipFile(t, outputPath, "index.json"), &index); err != nil {
+		t.Fatal(err)
+	}
+
+	if index.Revision != "kanjidic2.2026-10-01" {
+		t.Errorf("unexpected revision '%s'", index.Revision)
+	}
+	if !strings.Contains(index.Description, "file version 4") || !strings.Contains(index.Description, "database version 2026-274") {
+		t.Errorf("unexpected description '%s'", index.Description)
+	}
+}
+
+func TestKanjidicExportExtraTags(t *testing.T) {
+	dir, err := ioutil.TempDir("", "yomichan_test_")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	outputPath := filepath.Join(dir, "kanjidic.zip")
+	if err := kanjidicExportDb(filepath.Join("testdata", "kanjidic", "kanjidic2.xml"), outputPath, "", "", defaultStride, false, exportOptions{kanjidicExtras: "all"}); err != nil {
+		t.Fatal(err)
+	}
+
+	var tags [][]interface{}
+	if err := json.Unmarshal(readZipFile(t, outputPath, "tag_bank_1.json"), &tags); err != nil {
+		t.Fatal(err)
+	}
+
+	categories := make(map[string]string)
+	for _, tag := range tags {
+		categories[tag[0].(string)] = tag[1].(string)
+	}
+
+	var kanji [][]interface{}
+	if err := json.Unmarshal(readZipFile(t, outputPath, "kanji_bank_1.json"), &kanji); err != nil {
+		t.Fatal(err)
+	}
+
+	// Yomichan only displays stats whose tags are in these categories.
+	displayed := []string{"misc", "class", "code", "index"}
+	for _, entry := range kanji {
+		for name := range entry[5].(map[string]interface{}) {
+			if !hasString(categories[name], displayed) {
+				t.Errorf("stat '%s' of %s has category '%s', which is not displayed", name, entry[0], categories[name])
+			}
+		}
+	}
+}
@@ -0,0 +1,147 @@
+/*
+ * Copyright (c) 2016 Alex Yatskov <alex@foosoft.net>
+ * Author: Alex Yatskov <alex@foosoft.net>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of
+ * this software and associated documentation files (the "Software"), to deal in
+ * the Software without restriction, including without limitation the rights to
+ * use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+ * the Software, and to permit persons to whom the Software is furnished to do so,
+ * subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+ * FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+ * COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+ * IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+ * CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"strings"
+)
+
+// kanjiLists holds supplementary per-kanji tags and stats read from TSV files.
+// Each data line holds a kanji followed by tab separated fields, where a plain
+// field is a tag and a name=value field is a stat. Lines starting with "!"
+// describe a tag or stat for the tag bank as name, category and notes.
+type kanjiLists struct {
+	tags  map[string][]string
+	stats map[string]map[string]string
+	meta  dbTagList
+}
+
+func kanjiListsLoad(paths string) (*kanjiLists, error) {
+	lists := kanjiLists{
+		tags:  make(map[string][]string),
+		stats: make(map[string]map[string]string),
+	}
+
+	var (
+		names   []string
+		defined = make(map[string]bool)
+	)
+
+	for _, path := range strings.Split(paths, ",") {
+		if path = strings.TrimSpace(path); len(path) == 0 {
+			continue
+		}
+
+		reader, err := openInput(path)
+		if err != nil {
+			return nil, err
+		}
+
+		scanner := bufio.NewScanner(reader)
+		for lineNumber := 1; scanner.Scan(); lineNumber++ {
+			line := strings.TrimRight(scanner.Text(), "\r")
+			if len(strings.TrimSpace(line)) == 0 || strings.HasPrefix(line, "#") {
+				continue
+			}
+
+			fields := strings.Split(line, "\t")
+
+			if strings.HasPrefix(line, "!") {
+				if len(fields) < 2 {
+					reader.Close()
+					return nil, fmt.Errorf("%s:%d: expected a tag definition of name, category and notes", path, lineNumber)
+				}
+
+				tag := dbTag{Name: strings.TrimPrefix(fields[0], "!"), Category: fields[1]}
+				if len(fields) > 2 {
+					tag.Notes = fields[2]
+				}
+
+				if !defined[tag.Name] {
+					defined[tag.Name] = true
+					lists.meta = append(lists.meta, tag)
+				}
+
+				continue
+			}
+
+			kanji := strings.TrimSpace(fields[0])
+			for _, field := range fields[1:] {
+				if field = strings.TrimSpace(field); len(field) == 0 {
+					continue
+				}
+
+				name := field
+				if parts := strings.SplitN(field, "=", 2); len(parts) == 2 {
+					name = parts[0]
+					if lists.stats[kanji] == nil {
+						lists.stats[kanji] = make(map[string]string)
+					}
+					lists.stats[kanji][name] = parts[1]
+				} else {
+					lists.tags[kanji] = appendStringUnique(lists.tags[kanji], name)
+				}
+
+				names = appendStringUnique(names, name)
+			}
+		}
+
+		err = scanner.Err()
+		reader.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	for _, name := range names {
+		if !defined[name] {
+			lists.meta = append(lists.meta, dbTag{Name: name, Category: "misc"})
+		}
+	}
+
+	return &lists, nil
+}
+
+func (lists *kanjiLists) apply(kanji *dbKanji) {
+	kanji.addTags(lists.tags[kanji.Character]...)
+	for name, value := range lists.stats[kanji.Character] {
+		kanji.Stats[name] = value
+	}
+}
+
+func (lists *kanjiLists) buildTagMeta(tags dbTagList) dbTagList {
+	existing := make(map[string]bool)
+	for _, tag := range tags {
+		existing[tag.Name] = true
+	}
+
+	for _, tag := range lists.meta {
+		if !existing[tag.Name] {
+			tags = append(tags, tag)
+		}
+	}
+
+	return tags
+}
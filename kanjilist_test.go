@@ -0,0 +1,64 @@
+/*
+ * Copyright (c) 2016 Alex Yatskov <alex@foosoft.net>
+ * Author: Alex Yatskov <alex@foosoft.net>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of
+ * this software and associated documentation files (the "Software"), to deal in
+ * the Software without restriction, including without limitation the rights to
+ * use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+ * the Software, and to permit persons to whom the Software is furnished to do so,
+ * subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+ * FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+ * COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+ * IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+ * CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package main
+
+import (
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestKanjiListsLoad(t *testing.T) {
+	paths := []string{
+		filepath.Join("testdata", "kanjilist", "levels.tsv"),
+		filepath.Join("testdata", "kanjilist", "school.tsv"),
+	}
+
+	lists, err := kanjiListsLoad(strings.Join(paths, ","))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	kanji := dbKanji{Character: "日", Tags: []string{"jouyou"}, Stats: map[string]string{"grade": "1"}}
+	lists.apply(&kanji)
+
+	if expected := []string{"jouyou", "N5", "year1"}; !reflect.DeepEqual(kanji.Tags, expected) {
+		t.Errorf("got tags %v, expected %v", kanji.Tags, expected)
+	}
+	if expected := map[string]string{"grade": "1", "kanken": "10"}; !reflect.DeepEqual(kanji.Stats, expected) {
+		t.Errorf("got stats %v, expected %v", kanji.Stats, expected)
+	}
+
+	tags := lists.buildTagMeta(dbTagList{dbTag{Name: "N1", Category: "frequent"}})
+	expected := dbTagList{
+		dbTag{Name: "N1", Category: "frequent"},
+		dbTag{Name: "N5", Category: "jlpt", Notes: "JLPT level N5"},
+		dbTag{Name: "kanken", Category: "misc", Notes: "Kanji Kentei level"},
+		dbTag{Name: "jouyou2010", Category: "misc"},
+		dbTag{Name: "year1", Category: "misc"},
+	}
+	if !reflect.DeepEqual(tags, expected) {
+		t.Errorf("got tag meta %+v, expected %+v", tags, expected)
+	}
+}
@@ -0,0 +1,206 @@
+/*
+ * Copyright (c) 2016 Alex Yatskov <alex@foosoft.net>
+ * Author: Alex Yatskov <alex@foosoft.net>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of
+ * this software and associated documentation files (the "Software"), to deal in
+ * the Software without restriction, including without limitation the rights to
+ * use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+ * the Software, and to permit persons to whom the Software is furnished to do so,
+ * subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+ * FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+ * COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+ * IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+ * CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+const kanjivgRevision = "kanjivg1"
+
+const (
+	kanjivgSvgHeader = `<svg xmlns="http://www.w3.org/2000/svg" xmlns:kvg="http://kanjivg.tagaini.net" width="109" height="109" viewBox="0 0 109 109">` +
+		`<g style="fill:none;stroke:#000000;stroke-width:3;stroke-linecap:round;stroke-linejoin:round;">`
+	kanjivgSvgFooter = `</g></svg>`
+)
+
+func kanjivgParseCode(code string) (string, bool) {
+	value, err := strconv.ParseInt(code, 16, 32)
+	if err != nil {
+		return "", false
+	}
+
+	return string(rune(value)), true
+}
+
+func kanjivgLoadDir(path string) (map[string][]byte, error) {
+	names, err := filepath.Glob(filepath.Join(path, "*.svg"))
+	if err != nil {
+		return nil, err
+	}
+
+	images := make(map[string][]byte)
+	for _, name := range names {
+		code := strings.TrimSuffix(filepath.Base(name), ".svg")
+		if strings.Contains(code, "-") {
+			continue
+		}
+
+		character, ok := kanjivgParseCode(code)
+		if !ok {
+			continue
+		}
+
+		data, err := ioutil.ReadFile(name)
+		if err != nil {
+			return nil, err
+		}
+
+		images[character] = data
+	}
+
+	return images, nil
+}
+
+// The combined kanjivg.xml file only holds the stroke groups of each kanji,
+// so they are wrapped into standalone SVG documents here.
+func kanjivgLoadXML(path string) (map[string][]byte, error) {
+	reader, err := openInput(path)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+
+	var (
+		images  = make(map[string][]byte)
+		decoder = xml.NewDecoder(reader)
+	)
+
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+
+		element, ok := token.(xml.StartElement)
+		if !ok || element.Name.Local != "kanji" {
+			continue
+		}
+
+		var kanji struct {
+			ID    string `xml:"id,attr"`
+			Inner []byte `xml:",innerxml"`
+		}
+
+		if err := decoder.DecodeElement(&kanji, &element); err != nil {
+			return nil, err
+		}
+
+		code := strings.TrimPrefix(kanji.ID, "kvg:kanji_")
+		if strings.Contains(code, "-") {
+			continue
+		}
+
+		character, ok := kanjivgParseCode(code)
+		if !ok {
+			continue
+		}
+
+		var buffer bytes.Buffer
+		buffer.WriteString(kanjivgSvgHeader)
+		buffer.Write(bytes.TrimSpace(kanji.Inner))
+		buffer.WriteString(kanjivgSvgFooter)
+
+		images[character] = buffer.Bytes()
+	}
+
+	return images, nil
+}
+
+func kanjivgLoad(path string) (map[string][]byte, error) {
+	stat, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if stat.IsDir() {
+		return kanjivgLoadDir(path)
+	}
+
+	return kanjivgLoadXML(path)
+}
+
+// Yomichan kanji entries cannot reference images, so stroke order diagrams
+// are exported as single character terms whose glossary is the image. They
+// have to live in the same archive as the media they point to, which is kept
+// apart from the KANJIDIC archive so its term bank stays empty.
+func kanjivgBuildTerms(kanji dbKanjiList, images, media map[string][]byte) dbTermList {
+	var terms dbTermList
+	for _, entry := range kanji {
+		image, ok := images[entry.Character]
+		if !ok {
+			continue
+		}
+
+		path := fmt.Sprintf("kanjivg/%05x.svg", []rune(entry.Character)[0])
+		media[path] = image
+
+		terms = append(terms, dbTerm{
+			Expression:     entry.Character,
+			DefinitionTags: []string{"kanjivg"},
+			Glossary:       []interface{}{makeStructuredContent(dbContentNode{Tag: "img", Path: path})},
+		})
+	}
+
+	return terms
+}
+
+func kanjivgWriteDb(outputPath, title string, kanji dbKanjiList, images map[string][]byte, stride int, pretty bool) error {
+	media := make(map[string][]byte)
+	terms := kanjivgBuildTerms(kanji, images, media)
+
+	strokesPath := siblingPath(outputPath, "strokes")
+	log.Printf("writing %d stroke order diagrams to '%s'...\n", len(terms), strokesPath)
+
+	tags := dbTagList{
+		dbTag{Name: "kanjivg", Notes: "Stroke order diagram (KanjiVG)", Category: "misc"},
+	}
+
+	recordData := map[string]dbRecordList{
+		"term": terms.crush(),
+		"tag":  tags.crush(),
+	}
+
+	return writeDb(
+		strokesPath,
+		strings.TrimSpace(title+" (stroke order)"),
+		kanjivgRevision,
+		"",
+		false,
+		recordData,
+		media,
+		stride,
+		pretty,
+	)
+}
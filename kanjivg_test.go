@@ -0,0 +1,126 @@
+/*
+ * Copyright (c) 2016 Alex Yatskov <alex@foosoft.net>
+ * Author: Alex Yatskov <alex@foosoft.net>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of
+ * this software and associated documentation files (the "Software"), to deal in
+ * the Software without restriction, including without limitation the rights to
+ * use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+ * the Software, and to permit persons to whom the Software is furnished to do so,
+ * subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+ * FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+ * COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+ * IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+ * CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestKanjivgLoad(t *testing.T) {
+	images, err := kanjivgLoad(filepath.Join("testdata", "kanjivg", "kanjivg.xml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(images) != 2 {
+		t.Fatalf("expected 2 images, got %d", len(images))
+	}
+
+	var svg struct {
+		XMLName xml.Name
+		Paths   []struct{} `xml:"g>g>path"`
+	}
+	if err := xml.Unmarshal(images["日"], &svg); err != nil {
+		t.Fatal(err)
+	}
+	if svg.XMLName.Local != "svg" || len(svg.Paths) != 4 {
+		t.Errorf("unexpected SVG document %s", images["日"])
+	}
+
+	images, err = kanjivgLoad(filepath.Join("testdata", "kanjivg", "svg"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(images) != 1 || !bytes.Contains(images["日"], []byte("<svg")) {
+		t.Errorf("unexpected images from directory %v", images)
+	}
+}
+
+func TestKanjidicExportKanjivg(t *testing.T) {
+	dir, err := ioutil.TempDir("", "yomichan_test_")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	outputPath := filepath.Join(dir, "kanjidic.zip")
+	options := exportOptions{kanjivgPath: filepath.Join("testdata", "kanjivg", "kanjivg.xml")}
+	if err := kanjidicExportDb(filepath.Join("testdata", "kanjidic", "kanjidic2.xml"), outputPath, "", "KANJIDIC", defaultStride, false, options); err != nil {
+		t.Fatal(err)
+	}
+
+	archive, err := zip.OpenReader(outputPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer archive.Close()
+
+	for _, file := range archive.File {
+		if strings.HasPrefix(file.Name, "term_bank_") || strings.HasPrefix(file.Name, "kanjivg/") {
+			t.Errorf("KANJIDIC archive should not contain '%s'", file.Name)
+		}
+	}
+
+	strokesPath := filepath.Join(dir, "kanjidic_strokes.zip")
+	if image := readZipFile(t, strokesPath, "kanjivg/065e5.svg"); !bytes.HasPrefix(image, []byte("<svg")) {
+		t.Errorf("unexpected image %s", image)
+	}
+
+	var index struct {
+		Title    string `json:"title"`
+		Revision string `json:"revision"`
+	}
+	if err := json.Unmarshal(readZipFile(t, strokesPath, "index.json"), &index); err != nil {
+		t.Fatal(err)
+	}
+	if index.Title != "KANJIDIC (stroke order)" || index.Revision != kanjivgRevision {
+		t.Errorf("unexpected index %+v", index)
+	}
+
+	var terms [][]interface{}
+	if err := json.Unmarshal(readZipFile(t, strokesPath, "term_bank_1.json"), &terms); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(terms) != 2 {
+		t.Fatalf("expected 2 stroke order terms, got %d", len(terms))
+	}
+
+	glossary := []interface{}{map[string]interface{}{
+		"type":    "structured-content",
+		"content": map[string]interface{}{"tag": "img", "path": "kanjivg/065e5.svg"},
+	}}
+	if terms[0][0] != "日" || !reflect.DeepEqual(terms[0][5], glossary) {
+		t.Errorf("unexpected term %v", terms[0])
+	}
+}
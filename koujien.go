@@ -95,11 +95,12 @@ func (e *koujienExtractor) extractTerms(entry epwingEntry, sequence int) []dbTer
 		for _, reading := range readings {
 			term := dbTerm{
 				Expression: reading,
-				Glossary:   []string{entry.Text},
+				Glossary:   []interface{}{entry.Text},
 				Sequence:   sequence,
 			}
 
 			e.exportRules(&term, tags)
+			epwingExportPartsOfSpeech(&term, tags)
 			terms = append(terms, term)
 		}
 
@@ -109,11 +110,12 @@ func (e *koujienExtractor) extractTerms(entry epwingEntry, sequence int) []dbTer
 				term := dbTerm{
 					Expression: expression,
 					Reading:    reading,
-					Glossary:   []string{entry.Text},
+					Glossary:   []interface{}{entry.Text},
 					Sequence:   sequence,
 				}
 
 				e.exportRules(&term, tags)
+				epwingExportPartsOfSpeech(&term, tags)
 				terms = append(terms, term)
 			}
 		}
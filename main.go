@@ -26,10 +26,10 @@ import (
 	"errors"
 	"flag"
 	"fmt"
-	"io/ioutil"
 	"log"
 	"os"
 	"path"
+	"path/filepath"
 	"strings"
 )
 
@@ -45,12 +45,38 @@ func usage() {
 	flag.PrintDefaults()
 }
 
-func exportDb(inputPath, outputPath, format, language, title string, stride int, pretty bool) error {
-	handlers := map[string]func(string, string, string, string, int, bool) error{
+type exportOptions struct {
+	referenceReport   string
+	languageLabels    bool
+	jmdictNotes       string
+	groupSenses       bool
+	jmdictFrequency   bool
+	irregularForms    string
+	includeTags       string
+	excludeTags       string
+	fieldTags         string
+	nameTypes         string
+	excludeNameTypes  string
+	splitNameTypes    bool
+	kanjidicExtras    string
+	kradfilePaths     string
+	kanjivgPath       string
+	kanjiListPaths    string
+	kanjidicFrequency bool
+	media             bool
+	epwingReader      string
+	toolPath          string
+	noCache           bool
+	refreshCache      bool
+}
+
+func exportDb(inputPath, outputPath, format, language, title string, stride int, pretty bool, options exportOptions) error {
+	handlers := map[string]func(string, string, string, string, int, bool, exportOptions) error{
 		"edict":     jmdictExportDb,
 		"enamdict":  jmnedictExportDb,
 		"epwing":    epwingExportDb,
 		"kanjidic":  kanjidicExportDb,
+		"radkfile":  radkfileExportDb,
 		"rikai":     rikaiExportDb,
 		"kanjifreq": frequencyKanjiExportDb,
 		"termfreq":  frequencyTermsExportDb,
@@ -62,7 +88,7 @@ func exportDb(inputPath, outputPath, format, language, title string, stride int,
 	}
 
 	log.Printf("converting '%s' to '%s' in '%s' format...", inputPath, outputPath, format)
-	if err := handler(inputPath, outputPath, strings.ToLower(language), title, stride, pretty); err != nil {
+	if err := handler(inputPath, outputPath, strings.ToLower(language), title, stride, pretty, options); err != nil {
 		log.Printf("conversion process failed: %s", err.Error())
 		return err
 	}
@@ -71,19 +97,49 @@ func exportDb(inputPath, outputPath, format, language, title string, stride int,
 	return nil
 }
 
-func makeTmpDir() (string, error) {
-	return ioutil.TempDir("", "yomichan_tmp_")
+func makeCacheDir() (string, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+
+	dir = filepath.Join(dir, "yomichan-import")
+	return dir, os.MkdirAll(dir, 0755)
 }
 
 func main() {
 	var (
-		format   = flag.String("format", "", "dictionary format [edict|enamdict|epwing|kanjidic|rikai]")
-		language = flag.String("language", defaultLanguage, "dictionary language (if supported)")
+		format   = flag.String("format", "", "dictionary format [edict|enamdict|epwing|kanjidic|radkfile|rikai] (required when input-path is - for stdin)")
+		language = flag.String("language", defaultLanguage, "dictionary language, name or ISO 639-2 code, comma separated (if supported)")
 		title    = flag.String("title", "", "dictionary title")
 		stride   = flag.Int("stride", defaultStride, "dictionary bank stride")
 		pretty   = flag.Bool("pretty", false, "output prettified dictionary JSON")
 	)
 
+	var options exportOptions
+	flag.BoolVar(&options.languageLabels, "language-labels", false, "label each glossary with its language code (if supported)")
+	flag.StringVar(&options.jmdictNotes, "jmdict-notes", "none", "JMdict sense notes to include [info,xref,ant,source,type|all|none]")
+	flag.BoolVar(&options.groupSenses, "group-senses", false, "merge all senses of a headword into a single term (JMdict only)")
+	flag.BoolVar(&options.jmdictFrequency, "jmdict-freq", false, "write news frequency buckets (nfXX) as term frequency data (JMdict only)")
+	flag.StringVar(&options.irregularForms, "irregular-forms", "keep", "handling of irregular and search-only forms [keep|secondary|exclude] (JMdict only)")
+	flag.StringVar(&options.includeTags, "include", "", "only keep terms with these tags, e.g. \"P,news+ichi\"")
+	flag.StringVar(&options.excludeTags, "exclude", "", "drop terms with these tags, e.g. \"arch,obs\"")
+	flag.StringVar(&options.fieldTags, "field", "", "only keep terms with these field tags (JMdict) or definition tags (other formats), e.g. \"med,comp\"")
+	flag.StringVar(&options.nameTypes, "name-types", "", "only keep names of these types, e.g. \"surname,given\" (JMnedict only)")
+	flag.StringVar(&options.excludeNameTypes, "exclude-name-types", "", "drop names of these types (JMnedict only)")
+	flag.BoolVar(&options.splitNameTypes, "split-name-types", false, "write one dictionary per name type next to output-path (JMnedict only)")
+	flag.StringVar(&options.kanjidicExtras, "kanjidic-extras", "none", "KANJIDIC stats to include [nanori,rad_name,radical,variant,pinyin,korean,vietnam|all|none]; radical adds rad_classical and rad_nelson, korean adds korean_r and korean_h, and the others add a stat of the same name")
+	flag.StringVar(&options.kradfilePaths, "kradfile", "", "KRADFILE/KRADFILE2 paths, comma separated, to add kanji components from (KANJIDIC only)")
+	flag.StringVar(&options.kanjivgPath, "kanjivg", "", "KanjiVG SVG directory or kanjivg.xml to write a stroke order dictionary from next to output-path (KANJIDIC only)")
+	flag.StringVar(&options.kanjiListPaths, "kanji-lists", "", "TSV files, comma separated, of extra kanji tags and name=value stats (KANJIDIC only)")
+	flag.BoolVar(&options.kanjidicFrequency, "kanjidic-freq", false, "write newspaper frequency ranks as kanji frequency data (KANJIDIC only)")
+	flag.StringVar(&options.referenceReport, "reference-report", "", "write unresolved cross-references to this path (EPWING only)")
+	flag.BoolVar(&options.media, "media", false, "extract images, but not audio, into the dictionary (EPWING native reader only)")
+	flag.StringVar(&options.epwingReader, "epwing-reader", "zero-epwing", "EPWING reader [zero-epwing|native]")
+	flag.StringVar(&options.toolPath, "zero-epwing", "", "path to the zero-epwing executable (defaults to the bundled one)")
+	flag.BoolVar(&options.noCache, "no-cache", false, "do not read or write the EPWING dump cache")
+	flag.BoolVar(&options.refreshCache, "refresh-cache", false, "ignore and overwrite the cached EPWING dump")
+
 	flag.Usage = usage
 	flag.Parse()
 
@@ -101,8 +157,10 @@ func main() {
 		outputPath = flag.Arg(1)
 	)
 
-	if _, err := os.Stat(inputPath); err != nil {
-		log.Fatalf("dictionary path '%s' does not exist", inputPath)
+	if inputPath != "-" {
+		if _, err := os.Stat(inputPath); err != nil {
+			log.Fatalf("dictionary path '%s' does not exist", inputPath)
+		}
 	}
 
 	if *format == "" {
@@ -112,7 +170,7 @@ func main() {
 		}
 	}
 
-	if err := exportDb(inputPath, outputPath, *format, *language, *title, *stride, *pretty); err != nil {
+	if err := exportDb(inputPath, outputPath, *format, *language, *title, *stride, *pretty, options); err != nil {
 		log.Fatal(err)
 	}
 }
@@ -126,11 +126,12 @@ func (e *meikyouExtractor) extractTerms(entry epwingEntry, sequence int) []dbTer
 		for _, reading := range readings {
 			term := dbTerm{
 				Expression: reading,
-				Glossary:   []string{entry.Text},
+				Glossary:   []interface{}{entry.Text},
 				Sequence:   sequence,
 			}
 
 			e.exportRules(&term, tags)
+			epwingExportPartsOfSpeech(&term, tags)
 			terms = append(terms, term)
 		}
 
@@ -140,11 +141,12 @@ func (e *meikyouExtractor) extractTerms(entry epwingEntry, sequence int) []dbTer
 				term := dbTerm{
 					Expression: expression,
 					Reading:    reading,
-					Glossary:   []string{entry.Text},
+					Glossary:   []interface{}{entry.Text},
 					Sequence:   sequence,
 				}
 
 				e.exportRules(&term, tags)
+				epwingExportPartsOfSpeech(&term, tags)
 				terms = append(terms, term)
 			}
 		}
@@ -0,0 +1,195 @@
+/*
+ * Copyright (c) 2016 Alex Yatskov <alex@foosoft.net>
+ * Author: Alex Yatskov <alex@foosoft.net>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of
+ * this software and associated documentation files (the "Software"), to deal in
+ * the Software without restriction, including without limitation the rights to
+ * use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+ * the Software, and to permit persons to whom the Software is furnished to do so,
+ * subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+ * FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+ * COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+ * IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+ * CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/text/encoding/japanese"
+)
+
+const radkfileRevision = "radkfile1"
+
+// The EDRDG radical files are distributed in EUC-JP, but UTF-8 conversions
+// are common enough that both are accepted.
+func radkfileReadText(path string) (string, error) {
+	reader, err := openInput(path)
+	if err != nil {
+		return "", err
+	}
+	defer reader.Close()
+
+	data, err := ioutil.ReadAll(reader)
+	if err != nil {
+		return "", err
+	}
+
+	if utf8.Valid(data) {
+		return string(data), nil
+	}
+
+	decoded, err := japanese.EUCJP.NewDecoder().Bytes(data)
+	if err != nil {
+		return "", err
+	}
+
+	return string(decoded), nil
+}
+
+func kradfileLoad(paths string) (map[string][]string, error) {
+	components := make(map[string][]string)
+
+	for _, path := range strings.Split(paths, ",") {
+		if path = strings.TrimSpace(path); len(path) == 0 {
+			continue
+		}
+
+		text, err := radkfileReadText(path)
+		if err != nil {
+			return nil, err
+		}
+
+		for scanner := bufio.NewScanner(strings.NewReader(text)); scanner.Scan(); {
+			line := scanner.Text()
+			if strings.HasPrefix(line, "#") {
+				continue
+			}
+
+			parts := strings.SplitN(line, ":", 2)
+			if len(parts) != 2 {
+				continue
+			}
+
+			kanji := strings.TrimSpace(parts[0])
+			if len(kanji) == 0 {
+				continue
+			}
+
+			components[kanji] = strings.Fields(parts[1])
+		}
+	}
+
+	return components, nil
+}
+
+type radkfileRadical struct {
+	radical string
+	strokes int
+	kanji   bytes.Buffer
+}
+
+func radkfileExtractRadicals(text string) ([]*radkfileRadical, error) {
+	var (
+		radicals []*radkfileRadical
+		current  *radkfileRadical
+	)
+
+	for scanner := bufio.NewScanner(strings.NewReader(text)); scanner.Scan(); {
+		line := strings.TrimSpace(scanner.Text())
+		if len(line) == 0 || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		if strings.HasPrefix(line, "$") {
+			fields := strings.Fields(line)
+			if len(fields) < 3 {
+				return nil, fmt.Errorf("malformed radical line '%s'", line)
+			}
+
+			strokes, err := strconv.Atoi(fields[2])
+			if err != nil {
+				return nil, fmt.Errorf("malformed stroke count in '%s'", line)
+			}
+
+			current = &radkfileRadical{radical: fields[1], strokes: strokes}
+			radicals = append(radicals, current)
+			continue
+		}
+
+		if current == nil {
+			return nil, fmt.Errorf("kanji listed before the first radical: '%s'", line)
+		}
+
+		current.kanji.WriteString(line)
+	}
+
+	return radicals, nil
+}
+
+func radkfileExportDb(inputPath, outputPath, language, title string, stride int, pretty bool, options exportOptions) error {
+	text, err := radkfileReadText(inputPath)
+	if err != nil {
+		return err
+	}
+
+	radicals, err := radkfileExtractRadicals(text)
+	if err != nil {
+		return err
+	}
+
+	var kanji dbKanjiList
+	for _, radical := range radicals {
+		kanji = append(kanji, dbKanji{
+			Character: radical.radical,
+			Tags:      []string{"radical"},
+			Meanings:  []string{radical.kanji.String()},
+			Stats: map[string]string{
+				"strokes": strconv.Itoa(radical.strokes),
+				"count":   strconv.Itoa(utf8.RuneCount(radical.kanji.Bytes())),
+			},
+		})
+	}
+
+	if title == "" {
+		title = "RADKFILE"
+	}
+
+	tags := dbTagList{
+		dbTag{Name: "radical", Notes: "Radical used for kanji lookup", Category: "class"},
+		dbTag{Name: "strokes", Notes: "Stroke count", Category: "misc"},
+		dbTag{Name: "count", Notes: "Number of kanji containing this radical", Category: "misc"},
+	}
+
+	recordData := map[string]dbRecordList{
+		"kanji": kanji.crush(),
+		"tag":   tags.crush(),
+	}
+
+	return writeDb(
+		outputPath,
+		title,
+		radkfileRevision,
+		"",
+		false,
+		recordData,
+		nil,
+		stride,
+		pretty,
+	)
+}
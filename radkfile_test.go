@@ -0,0 +1,105 @@
+/*
+ * Copyright (c) 2016 Alex Yatskov <alex@foosoft.net>
+ * Author: Alex Yatskov <alex@foosoft.net>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of
+ * this software and associated documentation files (the "Software"), to deal in
+ * the Software without restriction, including without limitation the rights to
+ * use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+ * the Software, and to permit persons to whom the Software is furnished to do so,
+ * subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+ * FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+ * COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+ * IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+ * CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestKradfileLoad(t *testing.T) {
+	components, err := kradfileLoad(filepath.Join("testdata", "radkfile", "kradfile"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[string][]string{
+		"日": {"日"},
+		"亜": {"｜", "一", "口"},
+	}
+
+	if !reflect.DeepEqual(components, expected) {
+		t.Errorf("got components %v, expected %v", components, expected)
+	}
+}
+
+func TestRadkfileExport(t *testing.T) {
+	dir, err := ioutil.TempDir("", "yomichan_test_")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	outputPath := filepath.Join(dir, "radkfile.zip")
+	if err := radkfileExportDb(filepath.Join("testdata", "radkfile", "radkfile"), outputPath, "", "", defaultStride, false, exportOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	var kanji [][]interface{}
+	if err := json.Unmarshal(readZipFile(t, outputPath, "kanji_bank_1.json"), &kanji); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(kanji) != 3 {
+		t.Fatalf("expected 3 radicals, got %d", len(kanji))
+	}
+
+	expected := []interface{}{"日", "", "", "radical", []interface{}{"日旭"}, map[string]interface{}{"strokes": "4", "count": "2"}}
+	if !reflect.DeepEqual(kanji[2], expected) {
+		t.Errorf("got radical %v, expected %v", kanji[2], expected)
+	}
+}
+
+func TestKanjidicComponents(t *testing.T) {
+	dir, err := ioutil.TempDir("", "yomichan_test_")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	outputPath := filepath.Join(dir, "kanjidic.zip")
+	options := exportOptions{kradfilePaths: filepath.Join("testdata", "radkfile", "kradfile")}
+	if err := kanjidicExportDb(filepath.Join("testdata", "kanjidic", "kanjidic2.xml"), outputPath, "", "", defaultStride, false, options); err != nil {
+		t.Fatal(err)
+	}
+
+	var kanji [][]interface{}
+	if err := json.Unmarshal(readZipFile(t, outputPath, "kanji_bank_1.json"), &kanji); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, entry := range kanji {
+		if entry[0] == "亜" {
+			if stats := entry[5].(map[string]interface{}); stats["components"] != "｜ 一 口" {
+				t.Errorf("unexpected components %v", stats["components"])
+			}
+			return
+		}
+	}
+
+	t.Error("亜 not found in output")
+}
@@ -142,7 +142,7 @@ func rikaiExtractTerms(rows *sql.Rows) (dbTermList, error) {
 	return terms, nil
 }
 
-func rikaiExportDb(inputPath, outputPath, language, title string, stride int, pretty bool) error {
+func rikaiExportDb(inputPath, outputPath, language, title string, stride int, pretty bool, options exportOptions) error {
 	db, err := sql.Open("sqlite3", inputPath)
 	if err != nil {
 		return err
@@ -159,6 +159,8 @@ func rikaiExportDb(inputPath, outputPath, language, title string, stride int, pr
 		return err
 	}
 
+	terms = terms.filter(makeTermFilter(options))
+
 	if title == "" {
 		title = "Rikai"
 	}
@@ -180,8 +182,10 @@ func rikaiExportDb(inputPath, outputPath, language, title string, stride int, pr
 		outputPath,
 		title,
 		rikaiRevision,
+		"",
 		true,
 		recordData,
+		nil,
 		stride,
 		pretty,
 	)
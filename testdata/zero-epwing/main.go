@@ -0,0 +1,58 @@
+/*
+ * Copyright (c) 2016 Alex Yatskov <alex@foosoft.net>
+ * Author: Alex Yatskov <alex@foosoft.net>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of
+ * this software and associated documentation files (the "Software"), to deal in
+ * the Software without restriction, including without limitation the rights to
+ * use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+ * the Software, and to permit persons to whom the Software is furnished to do so,
+ * subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+ * FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+ * COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+ * IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+ * CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+// Stand-in for zero-epwing used by the end-to-end tests. It prints the
+// file named by FAKE_EPWING_OUTPUT to stdout, each line of FAKE_EPWING_STDERR
+// to stderr, and exits with FAKE_EPWING_EXIT.
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+)
+
+func main() {
+	fmt.Fprintf(os.Stderr, "args: %s\n", strings.Join(os.Args[1:], " "))
+
+	if lines := os.Getenv("FAKE_EPWING_STDERR"); len(lines) > 0 {
+		for _, line := range strings.Split(lines, "\n") {
+			fmt.Fprintln(os.Stderr, line)
+		}
+	}
+
+	if path := os.Getenv("FAKE_EPWING_OUTPUT"); len(path) > 0 {
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+
+		os.Stdout.Write(data)
+	}
+
+	if code, err := strconv.Atoi(os.Getenv("FAKE_EPWING_EXIT")); err == nil {
+		os.Exit(code)
+	}
+}
@@ -94,7 +94,7 @@ func (e *wadaiExtractor) extractTerms(entry epwingEntry, sequence int) []dbTerm
 		term := dbTerm{
 			Expression: expression,
 			Reading:    reading,
-			Glossary:   []string{entry.Text},
+			Glossary:   []interface{}{entry.Text},
 			Sequence:   sequence,
 		}
 